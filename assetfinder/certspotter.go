@@ -1,24 +1,55 @@
 package main
 
 import (
+	"context"
 	"fmt"
 )
 
-func fetchCertSpotter(domain string) ([]string, error) {
+func init() {
+	register(&source{
+		name:        "certspotter",
+		description: "Certificate Transparency logs via the Cert Spotter API",
+		fetch:       fetchCertSpotter,
+	})
+}
+
+func fetchCertSpotter(ctx context.Context, domain string) ([]string, error) {
 	out := make([]string, 0)
 
-	fetchURL := fmt.Sprintf("https://certspotter.com/api/v0/certs?domain=%s", domain)
+	// results come a page at a time; each page after the
+	// first starts after the last issuance ID of the one before
+	after := ""
+	for {
+		fetchURL := fmt.Sprintf(
+			"https://api.certspotter.com/v1/issuances?domain=%s&include_subdomains=true&expand=dns_names",
+			domain,
+		)
+		if after != "" {
+			fetchURL += "&after=" + after
+		}
 
-	wrapper := []struct {
-		DNSNames []string `json:"dns_names"`
-	}{}
-	err := fetchJSON(fetchURL, &wrapper)
-	if err != nil {
-		return out, err
-	}
+		wrapper := []struct {
+			ID       string   `json:"id"`
+			DNSNames []string `json:"dns_names"`
+		}{}
+		err := fetchJSON(ctx, fetchURL, &wrapper)
+		if err != nil {
+			return out, err
+		}
+
+		if len(wrapper) == 0 {
+			break
+		}
+
+		for _, w := range wrapper {
+			out = append(out, w.DNSNames...)
+		}
 
-	for _, w := range wrapper {
-		out = append(out, w.DNSNames...)
+		last := wrapper[len(wrapper)-1].ID
+		if last == "" || last == after {
+			break
+		}
+		after = last
 	}
 
 	return out, nil
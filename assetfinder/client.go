@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// httpClient is shared by every source. It has no overall timeout of its
+// own: each request is bounded by the context it's made with instead
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+		MaxIdleConnsPerHost:   4,
+	},
+}
+
+// maxRetries is how many times a request is retried after
+// a network error, a 429 or a 5xx response
+var maxRetries = 3
+
+// retryBackoff is the delay before the first retry; it
+// doubles for each retry after that
+var retryBackoff = 2 * time.Second
+
+// A statusError is returned for responses that aren't a 2xx. The URL
+// is left out of the message because some of them contain API keys
+type statusError struct {
+	status int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.status, http.StatusText(e.status))
+}
+
+// retryable returns true if a request that got the status is worth trying again
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// httpGet requests fetchURL and returns the body of the response, retrying
+// with backoff on network errors, 429s and 5xx responses. Any other
+// non-2xx response is an error
+func httpGet(ctx context.Context, fetchURL string) ([]byte, error) {
+	var lastErr error
+	delay := retryBackoff
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return []byte{}, fmt.Errorf("%s (gave up after: %s)", ctx.Err(), lastErr)
+			}
+			delay *= 2
+		}
+
+		raw, wait, err := doGet(ctx, fetchURL)
+		if err == nil {
+			return raw, nil
+		}
+		lastErr = err
+
+		if ctx.Err() != nil {
+			return []byte{}, err
+		}
+
+		if se, ok := err.(statusError); ok && !retryable(se.status) {
+			return []byte{}, err
+		}
+
+		// honour Retry-After if the server sent one
+		if wait > delay {
+			delay = wait
+		}
+	}
+
+	return []byte{}, fmt.Errorf("%s (after %d retries)", lastErr, maxRetries)
+}
+
+// doGet makes a single request. For 429 and 503 responses the
+// Retry-After header is returned too, or zero if there wasn't one
+func doGet(ctx context.Context, fetchURL string) ([]byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fetchURL, nil)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+	req.Header.Set("User-Agent", "assetfinder")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		// unwrap the *url.Error so the URL (and any key in it)
+		// doesn't end up in the error report
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return []byte{}, 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// drain the body so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+		return []byte{}, retryAfter(res.Header.Get("Retry-After")), statusError{res.StatusCode}
+	}
+
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+
+	return raw, 0, nil
+}
+
+// retryAfter parses a Retry-After header given in seconds; the
+// HTTP date form isn't used by any of the sources so it's ignored
+func retryAfter(v string) time.Duration {
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func fetchJSON(ctx context.Context, fetchURL string, wrapper interface{}) error {
+	raw, err := httpGet(ctx, fetchURL)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, wrapper)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keys holds the API keys etc loaded from the config file
+var keys = make(map[string]string)
+
+// defaultConfigPath returns ~/.config/assetfinder/config
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "assetfinder", "config")
+}
+
+// loadConfig reads NAME=value lines from the config file at path.
+// Blank lines and lines starting with # are ignored. A missing file
+// is only an error if mustExist is set
+func loadConfig(path string, mustExist bool) error {
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && !mustExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s:%d: expected NAME=value", path, line)
+		}
+
+		keys[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return sc.Err()
+}
+
+// getKey returns the named key, from an environment
+// variable of the same name or the config file
+func getKey(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return keys[name]
+}
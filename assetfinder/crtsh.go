@@ -1,23 +1,34 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"time"
 )
 
-func fetchCrtSh(domain string) ([]string, error) {
-	resp, err := http.Get(
+func init() {
+	register(&source{
+		name:        "crtsh",
+		description: "Certificate Transparency logs via crt.sh",
+		// crt.sh is often slow to answer for big domains
+		timeout: 2 * time.Minute,
+		fetch:   fetchCrtSh,
+	})
+}
+
+func fetchCrtSh(ctx context.Context, domain string) ([]string, error) {
+	raw, err := httpGet(ctx,
 		fmt.Sprintf("https://crt.sh/?q=%%25.%s&output=json", domain),
 	)
 	if err != nil {
 		return []string{}, err
 	}
-	defer resp.Body.Close()
 
 	output := make([]string, 0)
 
-	dec := json.NewDecoder(resp.Body)
+	dec := json.NewDecoder(bytes.NewReader(raw))
 
 	// The crt.sh API is a little funky... It returns multiple
 	// JSON objects with no delimiter, so you just have to keep
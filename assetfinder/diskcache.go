@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errNotCached is the error for a query that isn't in
+// the cache when running with -offline
+var errNotCached = errors.New("not in the cache")
+
+// A diskCache keeps the names each source returned for each query,
+// as <dir>/<source>/<query>.json, so repeated runs don't have to ask
+// the sources again. Only queries that didn't fail are cached
+type diskCache struct {
+	dir string
+
+	// entries older than ttl are ignored, unless offline is set,
+	// in which case every entry is used regardless of its age
+	ttl     time.Duration
+	offline bool
+}
+
+type cacheEntry struct {
+	Source  string    `json:"source"`
+	Query   string    `json:"query"`
+	Fetched time.Time `json:"fetched"`
+	Names   []string  `json:"names"`
+}
+
+// defaultCacheDir returns ~/.cache/assetfinder
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "assetfinder")
+}
+
+func (c *diskCache) path(source, query string) string {
+	return filepath.Join(c.dir, url.PathEscape(source), url.PathEscape(query)+".json")
+}
+
+// get returns the cached names for the query, and false if there's
+// no entry, it's expired, or it can't be read for some reason
+func (c *diskCache) get(source, query string) ([]string, bool) {
+	raw, err := os.ReadFile(c.path(source, query))
+	if err != nil {
+		return nil, false
+	}
+
+	var e cacheEntry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, false
+	}
+
+	if !c.offline && time.Since(e.Fetched) > c.ttl {
+		return nil, false
+	}
+	return e.Names, true
+}
+
+// put stores the names for the query. The entry is written to a
+// temporary file first so a concurrent reader never sees half of it
+func (c *diskCache) put(source, query string, names []string) error {
+	p := c.path(source, query)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(cacheEntry{
+		Source:  source,
+		Query:   query,
+		Fetched: time.Now(),
+		Names:   names,
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
@@ -1,37 +1,35 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"net/http"
-	"os"
 )
 
-func fetchFacebook(domain string) ([]string, error) {
+func init() {
+	register(&source{
+		name:        "facebook",
+		description: "Certificate Transparency logs via the Facebook Graph API",
+		credentials: []string{"FB_APP_ID", "FB_APP_SECRET"},
+		fetch:       fetchFacebook,
+	})
+}
 
-	appId := os.Getenv("FB_APP_ID")
-	appSecret := os.Getenv("FB_APP_SECRET")
-	if appId == "" || appSecret == "" {
-		// fail silently because it's reasonable not to have
-		// the Facebook API creds
-		return []string{}, nil
-	}
+func fetchFacebook(ctx context.Context, domain string) ([]string, error) {
 
-	accessToken, err := facebookAuth(appId, appSecret)
-	if err != nil {
-		return []string{}, err
-	}
+	appId := getKey("FB_APP_ID")
+	appSecret := getKey("FB_APP_SECRET")
 
-	domains, err := getFacebookCerts(accessToken, domain)
+	accessToken, err := facebookAuth(ctx, appId, appSecret)
 	if err != nil {
 		return []string{}, err
 	}
 
-	return domains, nil
+	// any pages fetched before an error are still returned
+	return getFacebookCerts(ctx, accessToken, domain)
 }
 
-func getFacebookCerts(accessToken, query string) ([]string, error) {
+func getFacebookCerts(ctx context.Context, accessToken, query string) ([]string, error) {
 	out := make([]string, 0)
 	fetchURL := fmt.Sprintf(
 		"https://graph.facebook.com/certificates?fields=domains&access_token=%s&query=*.%s",
@@ -50,7 +48,7 @@ func getFacebookCerts(accessToken, query string) ([]string, error) {
 			} `json:"paging"`
 		}{}
 
-		err := fetchJSON(fetchURL, &wrapper)
+		err := fetchJSON(ctx, fetchURL, &wrapper)
 		if err != nil {
 			return out, err
 		}
@@ -69,25 +67,16 @@ func getFacebookCerts(accessToken, query string) ([]string, error) {
 	return out, nil
 }
 
-func facebookAuth(appId, appSecret string) (string, error) {
+func facebookAuth(ctx context.Context, appId, appSecret string) (string, error) {
 	authUrl := fmt.Sprintf(
 		"https://graph.facebook.com/oauth/access_token?client_id=%s&client_secret=%s&grant_type=client_credentials",
 		appId, appSecret,
 	)
 
-	resp, err := http.Get(authUrl)
-	if err != nil {
-		return "", err
-	}
-
-	defer resp.Body.Close()
-
-	dec := json.NewDecoder(resp.Body)
-
 	auth := struct {
 		AccessToken string `json:"access_token"`
 	}{}
-	err = dec.Decode(&auth)
+	err := fetchJSON(ctx, authUrl, &auth)
 	if err != nil {
 		return "", err
 	}
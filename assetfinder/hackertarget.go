@@ -3,14 +3,23 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"strings"
 )
 
-func fetchHackerTarget(domain string) ([]string, error) {
+func init() {
+	register(&source{
+		name:        "hackertarget",
+		description: "Passive DNS via the HackerTarget host search API",
+		fetch:       fetchHackerTarget,
+	})
+}
+
+func fetchHackerTarget(ctx context.Context, domain string) ([]string, error) {
 	out := make([]string, 0)
 
-	raw, err := httpGet(
+	raw, err := httpGet(ctx,
 		fmt.Sprintf("https://api.hackertarget.com/hostsearch/?q=%s", domain),
 	)
 	if err != nil {
@@ -1,129 +1,321 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 func main() {
 	var subsOnly bool
 	flag.BoolVar(&subsOnly, "subs-only", false, "Only incluse subdomains of search domain")
+
+	var include, exclude string
+	flag.StringVar(&include, "sources", "", "Comma separated sources to use (default: all that are on by default)")
+	flag.StringVar(&exclude, "exclude-sources", "", "Comma separated sources not to use")
+
+	var listOnly bool
+	flag.BoolVar(&listOnly, "list-sources", false, "List the available sources and exit")
+
+	var jsonOutput bool
+	flag.BoolVar(&jsonOutput, "json", false, "Output JSON lines with the sources that found each name (printed once all sources are done)")
+
+	var timeout int
+	flag.IntVar(&timeout, "t", 30, "Timeout in seconds for each source, including retries (some slow sources allow longer)")
+
+	var deadline int
+	flag.IntVar(&deadline, "deadline", 0, "Give up on any sources still running after this many seconds (default: no deadline)")
+
+	var concurrency int
+	flag.IntVar(&concurrency, "c", 20, "Maximum number of source queries to run at once")
+
+	var sourceConcurrency int
+	flag.IntVar(&sourceConcurrency, "source-c", 2, "Maximum number of queries to run at once for each source (some sources allow fewer)")
+
+	var depth int
+	flag.IntVar(&depth, "depth", 0, "Query the sources that support it for the subdomains that are found, this many levels deep")
+
+	var resolve bool
+	flag.BoolVar(&resolve, "resolve", false, "Only output names that resolve and aren't under a wildcard DNS record")
+
+	var resolveConcurrency int
+	flag.IntVar(&resolveConcurrency, "resolve-c", 20, "Number of names to resolve at once with -resolve")
+
+	var useCache bool
+	flag.BoolVar(&useCache, "cache", false, "Cache what each source returns for each query on disk and reuse it on later runs")
+
+	var cacheDir string
+	flag.StringVar(&cacheDir, "cache-dir", "", "Directory for the cache (default: ~/.cache/assetfinder)")
+
+	var cacheTTL int
+	flag.IntVar(&cacheTTL, "cache-ttl", 24, "Hours before cached results are queried again")
+
+	var offline bool
+	flag.BoolVar(&offline, "offline", false, "Only use cached results, of any age, and never query the sources")
+
+	flag.IntVar(&maxRetries, "retries", maxRetries, "Times to retry a request after a network error, 429 or 5xx response")
+
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "Config file of API keys as NAME=value lines (default: ~/.config/assetfinder/config)")
 	flag.Parse()
 
-	domain := flag.Arg(0)
-	if domain == "" {
-		fmt.Println("no domain specified")
+	// the default config file doesn't have to exist, but one given with -config does
+	mustExist := configPath != ""
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	if err := loadConfig(configPath, mustExist); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %s\n", err)
 		return
 	}
-	domain = strings.ToLower(domain)
 
-	sources := []fetchFn{
-		fetchCertSpotter,
-		fetchHackerTarget,
-		fetchThreatCrowd,
-		fetchCrtSh,
-		fetchFacebook,
-		//fetchWayback, // A little too slow :(
-		fetchVirusTotal,
+	if listOnly {
+		listSources()
+		return
 	}
 
-	out := make(chan string)
-	var wg sync.WaitGroup
+	// with no domain argument, root domains are read from stdin,
+	// one per line, and each output line is tagged with its root
+	roots := make([]string, 0)
+	tagged := false
+	if flag.NArg() > 0 {
+		root, ok := cleanName(flag.Arg(0))
+		if !ok {
+			fmt.Fprintf(os.Stderr, "invalid domain: %s\n", flag.Arg(0))
+			return
+		}
+		roots = append(roots, root)
+	} else {
+		tagged = true
+		seen := make(map[string]bool)
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
 
-	// call each of the source workers in a goroutine
-	for _, source := range sources {
-		wg.Add(1)
-		fn := source
+			root, ok := cleanName(line)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "skipping invalid domain: %s\n", line)
+				continue
+			}
+			if seen[root] {
+				continue
+			}
+			seen[root] = true
+			roots = append(roots, root)
+		}
+		if err := sc.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read stdin: %s\n", err)
+			return
+		}
+	}
 
-		go func() {
-			defer wg.Done()
+	if len(roots) == 0 {
+		fmt.Println("no domain specified")
+		return
+	}
 
-			names, err := fn(domain)
+	sources, err := selectSources(include, exclude, !offline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return
+	}
 
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "err: %s\n", err)
-				return
-			}
+	var cache *diskCache
+	if useCache || offline {
+		if cacheDir == "" {
+			cacheDir = defaultCacheDir()
+		}
+		if cacheDir == "" {
+			fmt.Fprintln(os.Stderr, "no cache directory; use -cache-dir")
+			return
+		}
+		cache = &diskCache{
+			dir:     cacheDir,
+			ttl:     time.Duration(cacheTTL) * time.Hour,
+			offline: offline,
+		}
+	}
 
-			for _, n := range names {
-				out <- n
-			}
-		}()
+	ctx := context.Background()
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(deadline)*time.Second)
+		defer cancel()
 	}
 
-	// close the output channel when all the workers are done
+	out := make(chan found)
+
+	// errors are reported once everything's finished rather
+	// than getting mixed in with the output as they happen
+	var failures []failure
+	var skipped int
+
 	go func() {
-		wg.Wait()
+		failures, skipped = discover(ctx, sources, roots, out, searchOptions{
+			concurrency:       concurrency,
+			sourceConcurrency: sourceConcurrency,
+			timeout:           time.Duration(timeout) * time.Second,
+			depth:             depth,
+			cache:             cache,
+		})
 		close(out)
 	}()
 
-	// track what we've already printed to avoid duplicates
+	// with -resolve, names are checked by a pool of workers as
+	// they're found, and only printed if they're live
+	var res *resolver
+	var candidates chan found
+	var resolveWG sync.WaitGroup
+	var printMu sync.Mutex
+	if resolve {
+		if resolveConcurrency < 1 {
+			resolveConcurrency = 1
+		}
+		res = newResolver()
+		candidates = make(chan found)
+	}
+
+	printName := func(root, name string) {
+		printMu.Lock()
+		defer printMu.Unlock()
+		if tagged {
+			fmt.Printf("%s %s\n", root, name)
+		} else {
+			fmt.Println(name)
+		}
+	}
+
+	if resolve && !jsonOutput {
+		for i := 0; i < resolveConcurrency; i++ {
+			resolveWG.Add(1)
+			go func() {
+				defer resolveWG.Done()
+				for c := range candidates {
+					if _, ok := res.resolve(c.name, c.root); ok {
+						printName(c.root, c.name)
+					}
+				}
+			}()
+		}
+	}
+
+	// track what we've already printed to avoid duplicates; the
+	// same name found for two different roots is printed for both
 	printed := make(map[string]bool)
 
-	for n := range out {
-		n = cleanDomain(n)
-		if _, ok := printed[n]; ok {
+	// for JSON output, every name and the sources that found it
+	// are kept until the end, in the order they were first seen
+	records := make(map[string]*record)
+	order := make([]string, 0)
+
+	for f := range out {
+		n := f.name
+		isSub := isSubdomain(n, f.root)
+		if subsOnly && !isSub {
 			continue
 		}
-		if subsOnly && !strings.HasSuffix(n, domain) {
+
+		key := f.root + " " + n
+
+		if jsonOutput {
+			r, ok := records[key]
+			if !ok {
+				r = &record{Name: n, Root: f.root, Sources: make([]string, 0), FirstSeen: time.Now(), SubsOnly: isSub}
+				records[key] = r
+				order = append(order, key)
+			}
+			r.addSource(f.source)
 			continue
 		}
-		fmt.Println(n)
-		printed[n] = true
-	}
-}
 
-type fetchFn func(string) ([]string, error)
+		if _, ok := printed[key]; ok {
+			continue
+		}
+		printed[key] = true
 
-func httpGet(url string) ([]byte, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return []byte{}, err
+		if resolve {
+			candidates <- found{name: n, root: f.root}
+			continue
+		}
+		printName(f.root, n)
 	}
 
-	raw, err := ioutil.ReadAll(res.Body)
+	if resolve && !jsonOutput {
+		close(candidates)
+		resolveWG.Wait()
+	}
 
-	res.Body.Close()
-	if err != nil {
-		return []byte{}, err
+	if jsonOutput {
+		if resolve {
+			resolveRecords(res, records, resolveConcurrency)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		for _, key := range order {
+			r := records[key]
+			if resolve && len(r.Addresses) == 0 {
+				continue
+			}
+			enc.Encode(r)
+		}
 	}
 
-	return raw, nil
+	reportErrors(failures, skipped)
 }
 
-func cleanDomain(d string) string {
-	d = strings.ToLower(d)
+// record is the JSON output for a name
+type record struct {
+	Name      string    `json:"name"`
+	Root      string    `json:"root"`
+	Sources   []string  `json:"sources"`
+	FirstSeen time.Time `json:"first_seen"`
 
-	// no idea what this is, but we can't clean it ¯\_(ツ)_/¯
-	if len(d) < 2 {
-		return d
-	}
+	// SubsOnly is true if the name passes the -subs-only check
+	SubsOnly bool `json:"subs_only"`
 
-	if d[0] == '*' || d[0] == '%' {
-		d = d[1:]
-	}
+	// Addresses are only looked up with -resolve
+	Addresses []string `json:"addresses,omitempty"`
+}
 
-	if d[0] == '.' {
-		d = d[1:]
-	}
+// resolveRecords sets the addresses for each of the records
+// that resolves and isn't under a wildcard DNS record
+func resolveRecords(res *resolver, records map[string]*record, concurrency int) {
+	jobs := make(chan *record)
+	var wg sync.WaitGroup
 
-	return d
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				if addrs, ok := res.resolve(r.Name, r.Root); ok {
+					r.Addresses = addrs
+				}
+			}
+		}()
+	}
 
+	for _, r := range records {
+		jobs <- r
+	}
+	close(jobs)
+	wg.Wait()
 }
 
-func fetchJSON(url string, wrapper interface{}) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
+func (r *record) addSource(source string) {
+	for _, s := range r.Sources {
+		if s == source {
+			return
+		}
 	}
-	defer resp.Body.Close()
-	dec := json.NewDecoder(resp.Body)
-
-	return dec.Decode(wrapper)
+	r.Sources = append(r.Sources, source)
 }
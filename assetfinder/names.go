@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// cleanNames turns a raw value from a source into zero or more
+// hostnames. Some sources give several names in one value (crt.sh's
+// name_value has one per line), and others give email addresses or
+// URLs rather than hostnames, so each part is cleaned separately and
+// anything that doesn't end up as a valid hostname is dropped
+func cleanNames(raw string) []string {
+	out := make([]string, 0)
+	for _, part := range strings.Fields(raw) {
+		if n, ok := cleanName(part); ok {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// cleanName normalizes a single name: URLs become their hostname,
+// email addresses their domain, wildcard labels and leading and
+// trailing dots are stripped, and internationalized names are
+// converted to punycode. It returns false if the result isn't
+// a valid hostname
+func cleanName(n string) (string, bool) {
+	n = strings.TrimSpace(n)
+
+	if strings.Contains(n, "://") {
+		u, err := url.Parse(n)
+		if err != nil {
+			return "", false
+		}
+		n = u.Hostname()
+	}
+
+	if i := strings.LastIndex(n, "@"); i != -1 {
+		n = n[i+1:]
+	}
+
+	// host:port, but not IPv6 addresses, which are dropped below anyway
+	if strings.Count(n, ":") == 1 {
+		n = n[:strings.Index(n, ":")]
+	}
+
+	// *.example.com, %.example.com (crt.sh) and .example.com
+	for len(n) > 0 && (n[0] == '*' || n[0] == '%' || n[0] == '.') {
+		n = n[1:]
+	}
+	n = strings.TrimRight(n, ".")
+
+	// the idna package's lookup profile rejects underscores and some
+	// double hyphens that are fine in real names, so it's only used
+	// for names that actually need converting
+	if !isASCII(n) {
+		var err error
+		n, err = idna.Lookup.ToASCII(n)
+		if err != nil {
+			return "", false
+		}
+	}
+	n = strings.ToLower(n)
+
+	if !validHostname(n) {
+		return "", false
+	}
+	return n, true
+}
+
+// validHostname returns true for names made of at least two labels of
+// letters, digits, hyphens and underscores (which turn up in names like
+// _dmarc.example.com), with no label starting or ending with a hyphen.
+// IP addresses aren't hostnames, so they're invalid too
+func validHostname(n string) bool {
+	if len(n) == 0 || len(n) > 253 {
+		return false
+	}
+	if net.ParseIP(n) != nil {
+		return false
+	}
+
+	labels := strings.Split(n, ".")
+	if len(labels) < 2 {
+		return false
+	}
+
+	for _, l := range labels {
+		if len(l) == 0 || len(l) > 63 {
+			return false
+		}
+		if l[0] == '-' || l[len(l)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(l); i++ {
+			c := l[i]
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// isSubdomain returns true if n is root or a subdomain of it;
+// notexample.com isn't a subdomain of example.com
+func isSubdomain(n, root string) bool {
+	return n == root || strings.HasSuffix(n, "."+root)
+}
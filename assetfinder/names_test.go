@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsSubdomain(t *testing.T) {
+	cases := []struct {
+		name string
+		root string
+		want bool
+	}{
+		{"example.com", "example.com", true},
+		{"www.example.com", "example.com", true},
+		{"a.b.example.com", "example.com", true},
+		{"notexample.com", "example.com", false},
+		{"example.com.evil.com", "example.com", false},
+		{"com", "example.com", false},
+	}
+
+	for _, c := range cases {
+		have := isSubdomain(c.name, c.root)
+		if have != c.want {
+			t.Errorf("want %t for isSubdomain(%s, %s), have %t", c.want, c.name, c.root, have)
+		}
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"www.example.com", "www.example.com", true},
+		{"WWW.Example.COM", "www.example.com", true},
+		{"www.example.com.", "www.example.com", true},
+		{"*.example.com", "example.com", true},
+		{"%.example.com", "example.com", true},
+		{".example.com", "example.com", true},
+		{"admin@mail.example.com", "mail.example.com", true},
+		{"https://api.example.com:8443/path?q=1", "api.example.com", true},
+		{"api.example.com:8443", "api.example.com", true},
+		{"_dmarc.example.com", "_dmarc.example.com", true},
+		{"münchen.example.com", "xn--mnchen-3ya.example.com", true},
+		{"bücher.de", "xn--bcher-kva.de", true},
+
+		{"", "", false},
+		{"localhost", "", false},
+		{"10.0.0.1", "", false},
+		{"-bad.example.com", "", false},
+		{"bad-.example.com", "", false},
+		{"a..example.com", "", false},
+		{"spaces in.example.com", "", false},
+		{"example.com/path", "", false},
+	}
+
+	for _, c := range cases {
+		have, ok := cleanName(c.in)
+		if ok != c.ok || have != c.want {
+			t.Errorf("want (%q, %t) for cleanName(%q), have (%q, %t)", c.want, c.ok, c.in, have, ok)
+		}
+	}
+}
+
+func TestCleanNames(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want []string
+	}{
+		// crt.sh's name_value has one name per line
+		{"example.com\n*.example.com\nwww.example.com", []string{"example.com", "example.com", "www.example.com"}},
+		{"admin@example.com\nnot a hostname", []string{"example.com"}},
+		{"https://www.example.com/ 10.0.0.1", []string{"www.example.com"}},
+		{"", []string{}},
+	}
+
+	for _, c := range cases {
+		have := cleanNames(c.raw)
+		if strings.Join(have, ",") != strings.Join(c.want, ",") {
+			t.Errorf("want %v for cleanNames(%q), have %v", c.want, c.raw, have)
+		}
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"example.com", true},
+		{"a-b.example.com", true},
+		{"xn--mnchen-3ya.example.com", true},
+		{"example", false},
+		{"192.168.0.1", false},
+		{"::1", false},
+		{strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("a.", 127) + "com", false},
+		{"UPPER.example.com", false},
+	}
+
+	for _, c := range cases {
+		have := validHostname(c.name)
+		if have != c.want {
+			t.Errorf("want %t for validHostname(%q), have %t", c.want, c.name, have)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+)
+
+// A resolver checks that names resolve and aren't under a wildcard
+// DNS record, in the same way as strip-wildcards: if a random label
+// under any of a name's parents resolves then the name is dropped
+type resolver struct {
+	label string
+
+	mu        sync.Mutex
+	wildcards map[string]bool
+}
+
+func newResolver() *resolver {
+	return &resolver{
+		label:     randString(16),
+		wildcards: make(map[string]bool),
+	}
+}
+
+// resolve returns the addresses for name, and false if it
+// doesn't resolve or is under a wildcard below root
+func (r *resolver) resolve(name, root string) ([]string, bool) {
+	if r.underWildcard(name, root) {
+		return nil, false
+	}
+
+	addrs, err := net.LookupHost(name)
+	if err != nil || len(addrs) == 0 {
+		return nil, false
+	}
+	return addrs, true
+}
+
+// underWildcard checks root and every level between it and name. Given
+// one.two.example.com with a root of example.com it checks:
+//
+//	$rand.example.com
+//	$rand.two.example.com
+//
+// but not $rand.one.two.example.com, which would only tell us
+// if name has subdomains of its own. Names that aren't under
+// root are checked down from their registered domain instead
+func (r *resolver) underWildcard(name, root string) bool {
+	parts := strings.Split(name, ".")
+
+	top := len(parts) - 2
+	if strings.HasSuffix(name, "."+root) {
+		top = len(parts) - len(strings.Split(root, "."))
+	}
+
+	for i := top; i > 0; i-- {
+		if r.isWildcard(strings.Join(parts[i:], ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWildcard returns true if a random label under name resolves. Results
+// are cached, but two workers asking about the same name at the same
+// time might both look it up, which doesn't matter
+func (r *resolver) isWildcard(name string) bool {
+	r.mu.Lock()
+	v, ok := r.wildcards[name]
+	r.mu.Unlock()
+	if ok {
+		return v
+	}
+
+	_, err := net.LookupHost(fmt.Sprintf("%s.%s", r.label, name))
+
+	r.mu.Lock()
+	r.wildcards[name] = err == nil
+	r.mu.Unlock()
+	return err == nil
+}
+
+func randString(length int) string {
+	chars := []byte("abcdefghijklmnopqrstuvwxyz0123456789")
+	out := bytes.Buffer{}
+
+	for i := 0; i < length; i++ {
+		out.WriteByte(chars[rand.Intn(len(chars))])
+	}
+
+	return out.String()
+}
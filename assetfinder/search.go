@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// found is a cleaned name, the source that found it and
+// the root domain the source was queried for
+type found struct {
+	name   string
+	source string
+	root   string
+}
+
+// A query is a name to ask the sources about. For recursive
+// queries, name is a subdomain that was found for root
+type query struct {
+	name string
+	root string
+}
+
+// A failure is an error from querying a source for a name
+type failure struct {
+	source string
+	query  string
+	err    error
+}
+
+type searchOptions struct {
+	// concurrency is the most queries running at once across every source
+	concurrency int
+
+	// sourceConcurrency is the most queries running at once
+	// for each source that doesn't set its own limit
+	sourceConcurrency int
+
+	// timeout is the default timeout for each query
+	timeout time.Duration
+
+	// cache is nil unless -cache or -offline are used
+	cache *diskCache
+
+	// depth is how many times names that are found are queried in
+	// turn, using the sources that support querying subdomains
+	depth int
+}
+
+// discover searches for each of the root domains and, down to the
+// depth in opts, the new subdomains that turn up. Each level is a
+// separate round of searching, sending everything found to out;
+// results from recursive queries are still tagged with their root
+func discover(ctx context.Context, sources []*source, roots []string, out chan<- found, opts searchOptions) ([]failure, int) {
+	queries := make([]query, 0, len(roots))
+	queried := make(map[string]bool)
+	for _, root := range roots {
+		queries = append(queries, query{root, root})
+		queried[root] = true
+	}
+
+	failures := make([]failure, 0)
+	skipped := 0
+
+	for depth := 0; ; depth++ {
+		round := make(chan found)
+		go func(sources []*source, queries []query) {
+			f, s := search(ctx, sources, queries, round, opts)
+			failures = append(failures, f...)
+			skipped += s
+			close(round)
+		}(sources, queries)
+
+		next := make([]query, 0)
+		for f := range round {
+			out <- f
+
+			if depth >= opts.depth {
+				continue
+			}
+
+			if queried[f.name] || !isSubdomain(f.name, f.root) {
+				continue
+			}
+			queried[f.name] = true
+			next = append(next, query{f.name, f.root})
+		}
+
+		if len(next) == 0 || depth >= opts.depth || ctx.Err() != nil {
+			break
+		}
+
+		sources = recursiveSources(sources)
+		if len(sources) == 0 {
+			break
+		}
+		queries = next
+	}
+
+	return failures, skipped
+}
+
+// recursiveSources returns the sources that support querying subdomains
+func recursiveSources(sources []*source) []*source {
+	out := make([]*source, 0)
+	for _, s := range sources {
+		if s.recursive {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// search queries every source for every query, sending what
+// they find to out. Each source has its own workers so that a slow or
+// rate limited source doesn't hold up the others, and a shared
+// semaphore limits the total number of queries in flight. It returns
+// once all of the queries are done, without closing out, along with
+// the number of queries that weren't started because ctx was done
+func search(ctx context.Context, sources []*source, queries []query, out chan<- found, opts searchOptions) ([]failure, int) {
+	if opts.concurrency < 1 {
+		opts.concurrency = 1
+	}
+	if opts.sourceConcurrency < 1 {
+		opts.sourceConcurrency = 1
+	}
+	sem := make(chan struct{}, opts.concurrency)
+
+	failures := make([]failure, 0)
+	skipped := 0
+	var failuresMu sync.Mutex
+
+	var wg sync.WaitGroup
+	for _, s := range sources {
+		n := opts.sourceConcurrency
+		if s.concurrency > 0 {
+			n = s.concurrency
+		}
+
+		jobs := make(chan query)
+		go func() {
+			for _, q := range queries {
+				jobs <- q
+			}
+			close(jobs)
+		}()
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(s *source) {
+				defer wg.Done()
+
+				for q := range jobs {
+					if ctx.Err() != nil {
+						failuresMu.Lock()
+						skipped++
+						failuresMu.Unlock()
+						continue
+					}
+
+					names, err := ask(ctx, s, q.name, sem, opts)
+
+					// a source that fails part way through can still have
+					// found some names, so they're used either way
+					if err != nil {
+						failuresMu.Lock()
+						failures = append(failures, failure{s.name, q.name, err})
+						failuresMu.Unlock()
+					}
+
+					// names are cleaned here rather than by each source;
+					// the cache keeps them as the source returned them
+					for _, raw := range names {
+						for _, n := range cleanNames(raw) {
+							out <- found{n, s.name, q.root}
+						}
+					}
+				}
+			}(s)
+		}
+	}
+
+	wg.Wait()
+	return failures, skipped
+}
+
+// ask queries s for name, or gets the answer from the cache if
+// there's a fresh enough entry. The rate limit is waited for before
+// taking a slot from sem so that a queue for one source doesn't
+// block the rest
+func ask(ctx context.Context, s *source, name string, sem chan struct{}, opts searchOptions) ([]string, error) {
+	if opts.cache != nil {
+		if names, ok := opts.cache.get(s.name, name); ok {
+			return names, nil
+		}
+		if opts.cache.offline {
+			return []string{}, errNotCached
+		}
+	}
+
+	if err := s.wait(ctx); err != nil {
+		return []string{}, err
+	}
+
+	sem <- struct{}{}
+	names, err := s.run(ctx, name, opts.timeout)
+	<-sem
+
+	if err == nil && opts.cache != nil {
+		if err := opts.cache.put(s.name, name, names); err != nil {
+			return names, fmt.Errorf("failed to cache results: %s", err)
+		}
+	}
+	return names, err
+}
+
+// reportErrors prints the failures, sorted by source and then query
+func reportErrors(failures []failure, skipped int) {
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%d queries weren't started before the deadline\n", skipped)
+	}
+	if len(failures) == 0 {
+		return
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].source != failures[j].source {
+			return failures[i].source < failures[j].source
+		}
+		return failures[i].query < failures[j].query
+	})
+
+	fmt.Fprintf(os.Stderr, "%d queries failed:\n", len(failures))
+	for _, f := range failures {
+		fmt.Fprintf(os.Stderr, "  %s (%s): %s\n", f.source, f.query, f.err)
+	}
+}
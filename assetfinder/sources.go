@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fetchFn func(context.Context, string) ([]string, error)
+
+// A source is somewhere names can be found for a domain
+type source struct {
+	name        string
+	description string
+
+	// credentials are the names of the keys the source needs; see getKey
+	credentials []string
+
+	// rateLimit is the minimum time between queries to the source
+	rateLimit time.Duration
+
+	// timeout overrides the -t timeout for sources that are
+	// known to be slow; it includes the time spent retrying
+	timeout time.Duration
+
+	// recursive sources give useful results when they're queried for a
+	// subdomain, rather than already returning everything under the root
+	recursive bool
+
+	// concurrency overrides -source-c, the number of root
+	// domains the source is queried for at once
+	concurrency int
+
+	// sources that are off by default are only used
+	// when they're asked for by name with -sources
+	off bool
+
+	fetch fetchFn
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// registry holds every source by name. Sources add
+// themselves with register in an init function
+var registry = make(map[string]*source)
+
+func register(s *source) {
+	registry[s.name] = s
+}
+
+// allSources returns every registered source, sorted by name
+func allSources() []*source {
+	out := make([]*source, 0, len(registry))
+	for _, s := range registry {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].name < out[j].name
+	})
+	return out
+}
+
+// missingCredentials returns the names of any keys the
+// source needs that haven't been provided
+func (s *source) missingCredentials() []string {
+	out := make([]string, 0)
+	for _, c := range s.credentials {
+		if getKey(c) == "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// wait blocks until the source's rate limit allows another
+// query, or returns an error if ctx is done first
+func (s *source) wait(ctx context.Context) error {
+	if s.rateLimit == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d := time.Until(s.last.Add(s.rateLimit)); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.last = time.Now()
+	return nil
+}
+
+// run queries the source for domain, giving up after the
+// source's timeout, or def if it doesn't have one. It doesn't
+// wait for the rate limit; that's up to the caller, so that time
+// spent waiting doesn't count towards the timeout
+func (s *source) run(ctx context.Context, domain string, def time.Duration) ([]string, error) {
+	timeout := def
+	if s.timeout > 0 {
+		timeout = s.timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return s.fetch(ctx, domain)
+}
+
+// selectSources works out which sources to use from comma separated lists
+// of names to include and exclude. With no includes, every source that's on
+// by default is used. Unless keys is false (e.g. when only the cache is
+// being used), sources without the credentials they need are skipped,
+// with a warning if they were asked for by name
+func selectSources(include, exclude string, keys bool) ([]*source, error) {
+	excluded := make(map[string]bool)
+	for _, name := range splitNames(exclude) {
+		if _, ok := registry[name]; !ok {
+			return nil, fmt.Errorf("unknown source '%s'", name)
+		}
+		excluded[name] = true
+	}
+
+	named := make(map[string]bool)
+	for _, name := range splitNames(include) {
+		if _, ok := registry[name]; !ok {
+			return nil, fmt.Errorf("unknown source '%s'", name)
+		}
+		named[name] = true
+	}
+
+	out := make([]*source, 0)
+	for _, s := range allSources() {
+		if excluded[s.name] {
+			continue
+		}
+		if len(named) > 0 && !named[s.name] {
+			continue
+		}
+		if len(named) == 0 && s.off {
+			continue
+		}
+
+		if missing := s.missingCredentials(); keys && len(missing) > 0 {
+			if named[s.name] {
+				fmt.Fprintf(os.Stderr, "skipping source %s: missing %s\n", s.name, strings.Join(missing, ", "))
+			}
+			continue
+		}
+
+		out = append(out, s)
+	}
+
+	return out, nil
+}
+
+func splitNames(s string) []string {
+	out := make([]string, 0)
+	for _, n := range strings.Split(s, ",") {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n != "" {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// listSources prints a description of every source
+func listSources() {
+	for _, s := range allSources() {
+		status := "on"
+		if s.off {
+			status = "off by default"
+		}
+		if missing := s.missingCredentials(); len(missing) > 0 {
+			status = "missing " + strings.Join(missing, ", ")
+		}
+
+		fmt.Printf("%-14s %s (%s)\n", s.name, s.description, status)
+		if len(s.credentials) > 0 {
+			fmt.Printf("%-14s   keys: %s\n", "", strings.Join(s.credentials, ", "))
+		}
+		if s.rateLimit > 0 {
+			fmt.Printf("%-14s   rate limit: one query every %s\n", "", s.rateLimit)
+		}
+		if s.timeout > 0 {
+			fmt.Printf("%-14s   timeout: %s\n", "", s.timeout)
+		}
+		if s.recursive {
+			fmt.Printf("%-14s   used for recursive queries\n", "")
+		}
+		if s.concurrency > 0 {
+			fmt.Printf("%-14s   concurrency: %d\n", "", s.concurrency)
+		}
+	}
+}
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 )
 
-func fetchThreatCrowd(domain string) ([]string, error) {
+func init() {
+	register(&source{
+		name:        "threatcrowd",
+		description: "Passive DNS and malware data via the ThreatCrowd API",
+		// only the immediate subdomains are listed
+		recursive: true,
+		fetch:     fetchThreatCrowd,
+	})
+}
+
+func fetchThreatCrowd(ctx context.Context, domain string) ([]string, error) {
 	out := make([]string, 0)
 
 	fetchURL := fmt.Sprintf("https://www.threatcrowd.org/searchApi/v2/domain/report/?domain=%s", domain)
@@ -12,7 +23,7 @@ func fetchThreatCrowd(domain string) ([]string, error) {
 	wrapper := struct {
 		Subdomains []string `json:"subdomains"`
 	}{}
-	err := fetchJSON(fetchURL, &wrapper)
+	err := fetchJSON(ctx, fetchURL, &wrapper)
 	if err != nil {
 		return out, err
 	}
@@ -1,18 +1,29 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"time"
 )
 
-func fetchVirusTotal(domain string) ([]string, error) {
+func init() {
+	register(&source{
+		name:        "virustotal",
+		description: "Passive DNS via the VirusTotal API",
+		credentials: []string{"VT_API_KEY"},
+		// the public API allows 4 requests a minute
+		rateLimit: 15 * time.Second,
+		// there's no point running more than one at a time
+		concurrency: 1,
+		// only the immediate subdomains are listed
+		recursive: true,
+		fetch:     fetchVirusTotal,
+	})
+}
+
+func fetchVirusTotal(ctx context.Context, domain string) ([]string, error) {
 
-	apiKey := os.Getenv("VT_API_KEY")
-	if apiKey == "" {
-		// swallow not having an API key, just
-		// don't fetch
-		return []string{}, nil
-	}
+	apiKey := getKey("VT_API_KEY")
 
 	fetchURL := fmt.Sprintf(
 		"https://www.virustotal.com/vtapi/v2/domain/report?domain=%s&apikey=%s",
@@ -23,6 +34,6 @@ func fetchVirusTotal(domain string) ([]string, error) {
 		Subdomains []string `json:"subdomains"`
 	}{}
 
-	err := fetchJSON(fetchURL, &wrapper)
+	err := fetchJSON(ctx, fetchURL, &wrapper)
 	return wrapper.Subdomains, err
 }
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 )
 
-func fetchWayback(domain string) ([]string, error) {
+func init() {
+	register(&source{
+		name:        "wayback",
+		description: "Hostnames of URLs archived by the Wayback Machine",
+		// A little too slow :(
+		off:   true,
+		fetch: fetchWayback,
+	})
+}
+
+func fetchWayback(ctx context.Context, domain string) ([]string, error) {
 
 	fetchURL := fmt.Sprintf("http://web.archive.org/cdx/search/cdx?url=*.%s/*&output=json&collapse=urlkey", domain)
 
 	var wrapper [][]string
-	err := fetchJSON(fetchURL, &wrapper)
+	err := fetchJSON(ctx, fetchURL, &wrapper)
 	if err != nil {
 		return []string{}, err
 	}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"sync"
+	"time"
+)
+
+// batchSize is how many commands or imported records go in each
+// transaction, set with -batch. SQLite commits are slow because they
+// wait for the data to be written to disk, so doing one for every
+// line of a big import takes far longer than the inserts themselves
+var batchSize int
+
+// batchTimeout is how long a transaction is left open before it's
+// committed even if it isn't full. Other bbdb processes can't write
+// while it's open, so a slow pipe mustn't hold it for -batch lines
+const batchTimeout = time.Second
+
+// A batch runs commands in transactions of up to size commands each.
+// It's locked from next until done, because a transaction that's been
+// open too long is committed from another goroutine
+type batch struct {
+	db   *sql.DB
+	size int
+
+	mu    sync.Mutex
+	tx    *sql.Tx
+	n     int
+	timer *time.Timer
+
+	// err is from a commit made when a transaction timed out,
+	// returned by the next call to next or commit
+	err error
+}
+
+func newBatch(db *sql.DB) *batch {
+	size := batchSize
+	if size < 1 {
+		size = 1
+	}
+	return &batch{db: db, size: size}
+}
+
+// next returns the transaction to run the next command in, starting
+// one if there isn't one open. done must be called after the command
+func (b *batch) next() (dbtx, error) {
+	b.mu.Lock()
+
+	if b.err != nil {
+		err := b.err
+		b.err = nil
+		b.mu.Unlock()
+		return nil, err
+	}
+
+	if b.tx == nil {
+		tx, err := b.db.Begin()
+		if err != nil {
+			b.mu.Unlock()
+			return nil, err
+		}
+		b.tx = tx
+		b.n = 0
+		b.timer = time.AfterFunc(batchTimeout, b.timeout)
+	}
+
+	return b.tx, nil
+}
+
+// done counts the command run in the transaction from next,
+// and commits the transaction if it's full
+func (b *batch) done() error {
+	defer b.mu.Unlock()
+
+	b.n++
+	if b.n < b.size {
+		return nil
+	}
+	return b.commitTx()
+}
+
+// timeout commits the current transaction once it's been open for
+// batchTimeout, unless a command is being run in it
+func (b *batch) timeout() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.commitTx()
+	if err != nil {
+		b.err = err
+	}
+}
+
+// commit commits the current transaction, if there is one
+func (b *batch) commit() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		err := b.err
+		b.err = nil
+		return err
+	}
+	return b.commitTx()
+}
+
+// commitTx does the work for done, timeout and commit,
+// with the batch already locked
+func (b *batch) commitTx() error {
+	if b.tx == nil {
+		return nil
+	}
+	b.timer.Stop()
+	err := b.tx.Commit()
+	b.tx = nil
+	return err
+}
+
+// interactive returns true if stdin is a terminal. Commands typed
+// in are committed as soon as they've run, so nothing's lost if bbdb
+// is stopped with ctrl+c, and other bbdb processes can write while
+// it waits for the next one
+func interactive() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
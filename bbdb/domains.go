@@ -1,53 +1,78 @@
 package main
 
 import (
-	"database/sql"
-	"errors"
+	"fmt"
 	"strings"
 )
 
 type domains struct {
-	db *sql.DB
+	db dbtx
 }
 
 func (d *domains) names() []string {
 	return []string{"domain", "domains"}
 }
 
-func (d *domains) setDB(db *sql.DB) {
+func (d *domains) setDB(db dbtx) {
 	d.db = db
 }
 
-func (d *domains) initModule() error {
-	_, err := d.db.Exec(`
-		create table if not exists domains (
-			id integer primary key,
-			domain text unique not null
-		)
-	`)
+func (d *domains) migrations() []migration {
+	return []migration{
+		execMigration(`
+			create table if not exists domains (
+				id integer primary key,
+				domain text unique not null
+			)
+		`),
+		provenanceMigration("domains"),
+	}
+}
 
-	return err
+func (d *domains) schema() schema {
+	return schema{
+		table:   "domains",
+		columns: []string{"domain"},
+		tracked: true,
+		match:   "domain",
+	}
 }
 
-func (d *domains) Add(domain string) error {
+func (d *domains) find(domain string) (int64, error) {
+	domain, err := singleValue("domain", domain)
+	if err != nil {
+		return 0, err
+	}
+	return findID(d.db, domain, "select id from domains where domain = ?", normalise(domain))
+}
 
-	if domain == "" {
-		return errors.New("no domain provided")
+func (d *domains) Add(domain string) error {
+	domain, err := singleValue("domain", domain)
+	if err != nil {
+		return err
 	}
 	domain = normalise(domain)
 
-	_, err := d.db.Exec("insert or ignore into domains (domain) values(?)", domain)
+	s := newStamp()
+	_, err = d.db.Exec(`
+		insert into domains (domain, first_seen, last_seen, source, run_id) values(?, ?, ?, ?, ?)
+		on conflict (domain) do update set last_seen = excluded.last_seen
+	`, domain, s.at, s.at, s.source, s.run)
 	return err
 }
 
 func (d *domains) Delete(domain string) error {
-	if domain == "" {
-		return errors.New("no domain provided")
+	domain, err := singleValue("domain", domain)
+	if err != nil {
+		return err
 	}
 	domain = normalise(domain)
 
-	_, err := d.db.Exec("delete from domains where domain = ?", domain)
-	return err
+	_, err = d.db.Exec("delete from domains where domain = ?", domain)
+	if err != nil {
+		return err
+	}
+	return deleteRelations(d.db, "domain", domain)
 }
 
 func (d *domains) All() ([]string, error) {
@@ -71,6 +96,22 @@ func (d *domains) All() ([]string, error) {
 	return out, rows.Err()
 }
 
+// singleValue checks arg is one value for the types that only take
+// one. Arguments are the rest of the line, and it's easy to give more
+// (e.g. assetfinder's "root name" output), which would otherwise be
+// stored as a single value with a space in it
+func singleValue(typ, arg string) (string, error) {
+	t := strings.Fields(arg)
+	switch len(t) {
+	case 0:
+		return "", fmt.Errorf("no %s provided", typ)
+	case 1:
+		return t[0], nil
+	default:
+		return "", fmt.Errorf("expected one %s, have '%s'", typ, arg)
+	}
+}
+
 func normalise(domain string) string {
 	return strings.ToLower(domain)
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// findings are issues found on a target (a domain, URL, IP or
+// anything else), with a severity and a short title:
+//
+//	add finding high https://example.com/admin exposed admin panel
+//
+// They're listed with their id, which is what delete takes
+type findings struct {
+	db dbtx
+}
+
+var severities = map[string]bool{
+	"info":     true,
+	"low":      true,
+	"medium":   true,
+	"high":     true,
+	"critical": true,
+}
+
+func (f *findings) names() []string {
+	return []string{"finding", "findings"}
+}
+
+func (f *findings) setDB(db dbtx) {
+	f.db = db
+}
+
+func (f *findings) migrations() []migration {
+	return []migration{
+		execMigration(`
+			create table if not exists findings (
+				id integer primary key,
+				severity text not null,
+				target text not null,
+				title text not null,
+				unique (target, title)
+			)
+		`),
+		provenanceMigration("findings"),
+	}
+}
+
+func (f *findings) schema() schema {
+	return schema{
+		table:   "findings",
+		columns: []string{"id", "severity", "target", "title"},
+		tracked: true,
+		match:   "target",
+	}
+}
+
+func (f *findings) find(arg string) (int64, error) {
+	id, err := parseID(arg)
+	if err != nil {
+		return 0, err
+	}
+	return findID(f.db, arg, "select id from findings where id = ?", id)
+}
+
+// Add stores a finding. Adding the same title for the
+// same target again updates its severity
+func (f *findings) Add(arg string) error {
+	t := strings.Fields(arg)
+	if len(t) < 3 {
+		return errors.New("expected a severity, a target and a title")
+	}
+
+	severity := strings.ToLower(t[0])
+	if !severities[severity] {
+		return fmt.Errorf("invalid severity: %s (want info, low, medium, high or critical)", t[0])
+	}
+
+	s := newStamp()
+	_, err := f.db.Exec(`
+		insert into findings (severity, target, title, first_seen, last_seen, source, run_id)
+		values(?, ?, ?, ?, ?, ?, ?)
+		on conflict (target, title) do update set
+			severity = excluded.severity,
+			last_seen = excluded.last_seen
+	`, severity, t[1], strings.Join(t[2:], " "), s.at, s.at, s.source, s.run)
+	return err
+}
+
+func (f *findings) Delete(arg string) error {
+	id, err := parseID(arg)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.db.Exec("delete from findings where id = ?", id)
+	if err != nil {
+		return err
+	}
+	return deleteRelations(f.db, "finding", fmt.Sprint(id))
+}
+
+func (f *findings) All() ([]string, error) {
+	rows, err := f.db.Query("select id, severity, target, title from findings")
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var id int64
+		var severity, target, title string
+		err = rows.Scan(&id, &severity, &target, &title)
+		if err != nil {
+			return out, err
+		}
+
+		out = append(out, fmt.Sprintf("%d %s %s %s", id, severity, target, title))
+	}
+
+	return out, rows.Err()
+}
+
+// get returns one finding, formatted the same way as All
+func (f *findings) get(id string) (string, error) {
+	var severity, target, title string
+	err := f.db.QueryRow("select severity, target, title from findings where id = ?", id).Scan(&severity, &target, &title)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %s %s", id, severity, target, title), nil
+}
+
+// parseID reads the id of a record for the modules that delete by id
+func parseID(arg string) (int64, error) {
+	if arg == "" {
+		return 0, errors.New("no id provided")
+	}
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id: %s", arg)
+	}
+	return id, nil
+}
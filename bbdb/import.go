@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runImport handles the import action, which adds records in bulk
+// from stdin or a file, batched into transactions:
+//
+//	waybackurls example.com | bbdb -source waybackurls import urls
+//	assetfinder -json example.com | bbdb -source assetfinder import domains -format jsonl
+//	bbdb import ports -format csv ports.csv
+func runImport(db *sql.DB, o op) {
+	mod, err := getModule(o, db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "module error: %s\n", err)
+		return
+	}
+
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	format := fs.String("format", "lines", "")
+
+	err = fs.Parse(o.args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import error: %s\n", err)
+		return
+	}
+
+	var in io.Reader = os.Stdin
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import error: %s\n", err)
+			return
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var records recordReader
+	switch *format {
+	case "lines":
+		records = newLineReader(in)
+	case "jsonl":
+		records = newJSONLReader(in)
+	case "csv":
+		records = newCSVReader(in)
+	default:
+		fmt.Fprintf(os.Stderr, "import error: unknown format: %s (want lines, jsonl or csv)\n", *format)
+		return
+	}
+
+	typ := mod.names()[0]
+	b := newBatch(db)
+	imported, failed := 0, 0
+
+	for {
+		rec, line, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = importRecord(b, mod, typ, rec)
+		}
+		if errors.Is(err, errTransaction) {
+			fmt.Fprintf(os.Stderr, "import error: %s\n", err)
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import error: line %d: %s\n", line, err)
+			failed++
+			continue
+		}
+		imported++
+	}
+
+	err = b.commit()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import error: %s\n", err)
+		return
+	}
+
+	fmt.Printf("Imported: %d %s", imported, mod.names()[1])
+	if failed > 0 {
+		fmt.Printf(" (%d failed)", failed)
+	}
+	fmt.Println()
+}
+
+var errTransaction = errors.New("transaction failed")
+
+// importRecord adds one record, in the batch's current transaction
+func importRecord(b *batch, mod module, typ string, rec importedRecord) error {
+	tx, err := b.next()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errTransaction, err)
+	}
+
+	err = addRecord(tx, mod, typ, rec)
+
+	txErr := b.done()
+	if txErr != nil {
+		return fmt.Errorf("%w: %s", errTransaction, txErr)
+	}
+	return err
+}
+
+// addRecord does the work for importRecord
+func addRecord(tx dbtx, mod module, typ string, rec importedRecord) error {
+	err := recordRun(tx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errTransaction, err)
+	}
+
+	arg := rec.line
+	if rec.fields != nil {
+		arg, err = importArg(typ, rec.fields)
+		if err != nil {
+			return err
+		}
+	}
+
+	mod.setDB(tx)
+	err = mod.Add(arg)
+	if err != nil {
+		return err
+	}
+
+	// assetfinder -resolve gives the addresses each name resolves to
+	if typ == "domain" && rec.fields != nil {
+		if addrs, ok := rec.fields["addresses"].([]interface{}); ok {
+			rel := &relations{db: tx}
+			for _, a := range addrs {
+				err = rel.Add(fmt.Sprintf("%s resolves-to %v", arg, a))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// An importedRecord is either a line to pass to a module's Add as it
+// is, or the fields of a JSON object or CSV row to be turned into one
+type importedRecord struct {
+	line   string
+	fields map[string]interface{}
+}
+
+type recordReader interface {
+	// next returns the next record and the line it was on
+	next() (importedRecord, int, error)
+}
+
+type lineReader struct {
+	sc     *bufio.Scanner
+	line   int
+	failed bool
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &lineReader{sc: sc}
+}
+
+func (l *lineReader) next() (importedRecord, int, error) {
+	for l.sc.Scan() {
+		l.line++
+		line := strings.TrimSpace(l.sc.Text())
+		if line == "" {
+			continue
+		}
+		return importedRecord{line: line}, l.line, nil
+	}
+	// the scanner stops at an error (like a line that's too long),
+	// so it's reported once and the import ends there
+	if err := l.sc.Err(); err != nil && !l.failed {
+		l.failed = true
+		return importedRecord{}, l.line + 1, err
+	}
+	return importedRecord{}, l.line, io.EOF
+}
+
+type jsonlReader struct {
+	lines *lineReader
+}
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	return &jsonlReader{lines: newLineReader(r)}
+}
+
+func (j *jsonlReader) next() (importedRecord, int, error) {
+	rec, line, err := j.lines.next()
+	if err != nil {
+		return rec, line, err
+	}
+
+	// UseNumber stops ports and status codes turning into 443.0
+	dec := json.NewDecoder(strings.NewReader(rec.line))
+	dec.UseNumber()
+
+	fields := map[string]interface{}{}
+	if err := dec.Decode(&fields); err != nil {
+		return importedRecord{}, line, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return importedRecord{fields: fields}, line, nil
+}
+
+// csvReader reads CSV with a header row naming the columns,
+// like the output of query -format csv. It counts rows rather
+// than lines, which are the same unless values have newlines
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+	row    int
+}
+
+func newCSVReader(r io.Reader) *csvReader {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = -1
+	return &csvReader{r: c}
+}
+
+func (c *csvReader) next() (importedRecord, int, error) {
+	for {
+		row, err := c.r.Read()
+		if err != nil {
+			return importedRecord{}, c.row, err
+		}
+		c.row++
+
+		if c.header == nil {
+			c.header = row
+			continue
+		}
+
+		fields := map[string]interface{}{}
+		for i, v := range row {
+			if i < len(c.header) {
+				fields[strings.TrimSpace(c.header[i])] = v
+			}
+		}
+		return importedRecord{fields: fields}, c.row, nil
+	}
+}
+
+// importArg turns the fields of an imported record into the argument
+// the module's Add takes. The fields can be those output by query
+// -format jsonl or csv, or by the other tools in this repo, like the
+// name in assetfinder -json's output, or the target, class and
+// severity of a cors-blimey finding
+func importArg(typ string, r map[string]interface{}) (string, error) {
+	var arg string
+	switch typ {
+	case "domain":
+		arg = field(r, "domain", "name", "host")
+
+	case "url":
+		arg = field(r, "url", "original", "target")
+
+	case "ip":
+		arg = field(r, "ip", "address")
+
+	case "port":
+		arg = strings.TrimSpace(fmt.Sprintf("%s %s/%s %s",
+			field(r, "host", "ip"), field(r, "port"), fieldOr(r, "tcp", "proto"), field(r, "service"),
+		))
+
+	case "response":
+		arg = fmt.Sprintf("%s %s", field(r, "url"), field(r, "status", "status_code"))
+		if tech := field(r, "tech"); tech != "" {
+			arg += " tech=" + strings.ReplaceAll(tech, " ", "")
+		}
+		arg += " " + field(r, "title")
+
+	case "finding":
+		// cors-blimey findings have a class of CORS
+		// misconfiguration, and kxss findings are
+		// reflected parameters
+		title := field(r, "title")
+		if c := field(r, "class"); title == "" && c != "" {
+			title = "CORS " + c
+		}
+		if p := field(r, "param"); title == "" && p != "" {
+			title = "reflected parameter " + p
+		}
+		arg = fmt.Sprintf("%s %s %s", fieldOr(r, "info", "severity"), field(r, "target", "url"), title)
+
+	case "note":
+		arg = fmt.Sprintf("%s %s", field(r, "target"), field(r, "note"))
+
+	case "relation":
+		arg = fmt.Sprintf("%s %s %s", field(r, "from_value", "from"), field(r, "kind"), field(r, "to_value", "to"))
+
+	default:
+		return "", fmt.Errorf("%s can't be imported", typ)
+	}
+
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return "", fmt.Errorf("no fields for a %s", typ)
+	}
+	return arg, nil
+}
+
+// field returns the first of keys that has a value in r. Lists
+// like the tech in JSONL output are joined with commas
+func field(r map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		switch v := r[k].(type) {
+		case nil:
+			continue
+		case string:
+			if v != "" {
+				return v
+			}
+		case []interface{}:
+			parts := make([]string, 0, len(v))
+			for _, p := range v {
+				parts = append(parts, fmt.Sprint(p))
+			}
+			if len(parts) > 0 {
+				return strings.Join(parts, ",")
+			}
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// fieldOr is field with a default for when none of the keys have a value
+func fieldOr(r map[string]interface{}, def string, keys ...string) string {
+	if v := field(r, keys...); v != "" {
+		return v
+	}
+	return def
+}
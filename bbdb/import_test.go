@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportArg(t *testing.T) {
+	cases := []struct {
+		typ  string
+		json string
+		want string
+	}{
+		// assetfinder -json
+		{"domain", `{"name":"api.example.com","root":"example.com","sources":["crtsh"],"addresses":["10.0.0.1"]}`, "api.example.com"},
+		// kxss -json, the example in its README
+		{"finding", `{"url":"http://testsite.com/search?query=test&page=1","param":"query","injection_point":"query","status":200,"content_type":"html","type_mismatch":false,"reflected_in":["body"],"reflections":2,"allowed":["\"","<",">"],"blocked":["'","(",")","` + "`" + `",";","{","}"],"snippets":["<input name=\"q\" value=\"testkXssRand0mStr1ng\">","<h1>Results for testkXssRand0mStr1ng</h1>"]}`, "info http://testsite.com/search?query=test&page=1 reflected parameter query"},
+		// cors-blimey -json
+		{"finding", `{"target":"https://example.com/","origin":"null","class":"null","severity":"high","method":"GET","preflight":false}`, "high https://example.com/ CORS null"},
+		// query -format jsonl
+		{"port", `{"host":"example.com","port":443,"proto":"tcp","service":"https"}`, "example.com 443/tcp https"},
+		{"response", `{"url":"https://example.com/","status":200,"title":"Home","tech":["nginx","php"]}`, "https://example.com/ 200 tech=nginx,php Home"},
+	}
+
+	for _, c := range cases {
+		rec, _, err := newJSONLReader(strings.NewReader(c.json)).next()
+		if err != nil {
+			t.Fatalf("expected nil error reading %s, have %s", c.json, err)
+		}
+
+		have, err := importArg(c.typ, rec.fields)
+		if err != nil {
+			t.Errorf("expected nil error from importArg(%s, %s), have %s", c.typ, c.json, err)
+			continue
+		}
+		if have != c.want {
+			t.Errorf("want %q for importArg(%s, %s), have %q", c.want, c.typ, c.json, have)
+		}
+	}
+
+	_, err := importArg("domain", map[string]interface{}{"root": "example.com"})
+	if err == nil {
+		t.Errorf("want error from importArg() with no domain field, have nil")
+	}
+}
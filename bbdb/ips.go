@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+type ips struct {
+	db dbtx
+}
+
+func (i *ips) names() []string {
+	return []string{"ip", "ips"}
+}
+
+func (i *ips) setDB(db dbtx) {
+	i.db = db
+}
+
+func (i *ips) migrations() []migration {
+	return []migration{
+		execMigration(`
+			create table if not exists ips (
+				id integer primary key,
+				ip text unique not null
+			)
+		`),
+		provenanceMigration("ips"),
+	}
+}
+
+func (i *ips) schema() schema {
+	return schema{
+		table:   "ips",
+		columns: []string{"ip"},
+		tracked: true,
+		match:   "ip",
+	}
+}
+
+func (i *ips) find(ip string) (int64, error) {
+	ip, err := singleValue("ip", ip)
+	if err != nil {
+		return 0, err
+	}
+	ip, err = normaliseIP(ip)
+	if err != nil {
+		return 0, err
+	}
+	return findID(i.db, ip, "select id from ips where ip = ?", ip)
+}
+
+func (i *ips) Add(ip string) error {
+	ip, err := singleValue("ip", ip)
+	if err != nil {
+		return err
+	}
+	ip, err = normaliseIP(ip)
+	if err != nil {
+		return err
+	}
+
+	s := newStamp()
+	_, err = i.db.Exec(`
+		insert into ips (ip, first_seen, last_seen, source, run_id) values(?, ?, ?, ?, ?)
+		on conflict (ip) do update set last_seen = excluded.last_seen
+	`, ip, s.at, s.at, s.source, s.run)
+	return err
+}
+
+func (i *ips) Delete(ip string) error {
+	ip, err := singleValue("ip", ip)
+	if err != nil {
+		return err
+	}
+	ip, err = normaliseIP(ip)
+	if err != nil {
+		return err
+	}
+
+	_, err = i.db.Exec("delete from ips where ip = ?", ip)
+	if err != nil {
+		return err
+	}
+	return deleteRelations(i.db, "ip", ip)
+}
+
+func (i *ips) All() ([]string, error) {
+	rows, err := i.db.Query("select ip from ips")
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var ip string
+		err = rows.Scan(&ip)
+		if err != nil {
+			return out, err
+		}
+
+		out = append(out, ip)
+	}
+
+	return out, rows.Err()
+}
+
+// normaliseIP checks ip is an IPv4 or IPv6 address and returns it in
+// its canonical form, so e.g. 2001:DB8::0:1 and 2001:db8::1 are the same
+func normaliseIP(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid ip: %s", ip)
+	}
+	return parsed.String(), nil
+}
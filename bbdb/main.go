@@ -7,20 +7,34 @@ import (
 	"fmt"
 	"os"
 	"strings"
-
-	_ "github.com/mattn/go-sqlite3"
+	"time"
 )
 
 var modules = []module{
 	&domains{},
+	&urls{},
+	&ips{},
+	&ports{},
+	&responses{},
+	&findings{},
+	&notes{},
+	&relations{},
+	&runs{},
 }
 
 func main() {
+	flag.StringVar(&current.source, "source", "", "the tool the records being added came from")
+	flag.StringVar(&current.run, "run", "", "an ID for this run (default: the time it started)")
+	flag.IntVar(&batchSize, "batch", 1000, "commands or imported records per transaction")
 	flag.Parse()
 
+	if current.run == "" {
+		current.run = time.Now().UTC().Format("20060102T150405Z")
+	}
+
 	sc := bufio.NewScanner(os.Stdin)
 
-	db, err := sql.Open("sqlite3", "./bbdb.db")
+	db, err := sql.Open(driverName, "./bbdb.db")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to open db: %s\n", err)
 		return
@@ -31,48 +45,70 @@ func main() {
 		return
 	}
 
-	// initialise db file
+	// create or upgrade the tables; init does nothing else,
+	// but every command makes sure they're up to date first
+	err = migrate(db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "init error: %s\n", err)
+		return
+	}
 	if flag.NArg() > 0 && flag.Arg(0) == "init" {
-		err := initModules(db)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "init error: %s\n", err)
-		}
 		return
 	}
 
-	// Handle commands from arguments
+	// Handle commands from arguments. They're used as they are rather
+	// than joined back into a line, so quoting in the shell still
+	// works for things like query's -regex
 	if flag.NArg() >= 2 {
-		action := flag.Arg(0)
-		typ := flag.Arg(1)
-		arg := ""
-		if flag.NArg() > 2 {
-			arg = flag.Arg(2)
+		o := newOp(flag.Args())
+		if o.action == "import" {
+			runImport(db, o)
+			return
 		}
 
-		// Construct the command line as if it came from stdin
-		// This allows us to reuse the tokenize and processing logic
-		cmdLine := fmt.Sprintf("%s %s %s", action, typ, arg)
-		processCommand(strings.TrimSpace(cmdLine), db)
+		processCommand(o, db)
 		return
 	}
 
 	// If no arguments are provided (or only 'init'), read from stdin
+	b := newBatch(db)
+	if interactive() {
+		b.size = 1
+	}
+
 	for sc.Scan() {
 		line := sc.Text()
 		if line == "" {
 			continue
 		}
-		processCommand(line, db)
+
+		op, err := tokenize(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "parse error: %s\n", err)
+			continue
+		}
+
+		tx, err := b.next()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "transaction error: %s\n", err)
+			return
+		}
+		processCommand(op, tx)
+
+		err = b.done()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "transaction error: %s\n", err)
+			return
+		}
 	}
-}
 
-func processCommand(line string, db *sql.DB) {
-	op, err := tokenize(line)
+	err = b.commit()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "parse error: %s\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "transaction error: %s\n", err)
 	}
+}
 
+func processCommand(op op, db dbtx) {
 	mod, err := getModule(op, db)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "module error: %s\n", err)
@@ -81,6 +117,12 @@ func processCommand(line string, db *sql.DB) {
 
 	switch op.action {
 	case "add":
+		err = recordRun(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "add error: %s\n", err)
+			return
+		}
+
 		err = mod.Add(op.arg)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "add error: %s\n", err)
@@ -95,7 +137,7 @@ func processCommand(line string, db *sql.DB) {
 			return
 		}
 		if len(vals) == 0 {
-			fmt.Println("No domains found.") // Provide feedback for CLI usage
+			fmt.Printf("No %s found.\n", mod.names()[1]) // Provide feedback for CLI usage
 			return
 		}
 		for _, v := range vals {
@@ -103,22 +145,73 @@ func processCommand(line string, db *sql.DB) {
 		}
 
 	case "delete":
+		// look the record up first so its tags can go too
+		id, findErr := mod.find(op.arg)
+
 		err = mod.Delete(op.arg)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "delete error: %s\n", err) // Corrected "add error" to "delete error"
 			return
 		}
+		if findErr == nil {
+			err = deleteTags(db, mod.names()[0], id)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "delete error: %s\n", err)
+				return
+			}
+		}
 		fmt.Printf("Deleted: %s\n", op.arg) // Provide feedback for CLI usage
+
+	case "tag", "untag":
+		err = tag(db, mod, op.action == "untag", op.args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s error: %s\n", op.action, err)
+			return
+		}
+		if op.action == "tag" {
+			fmt.Printf("Tagged: %s\n", op.arg)
+		} else {
+			fmt.Printf("Untagged: %s\n", op.arg)
+		}
+
+	case "import":
+		fmt.Fprintf(os.Stderr, "import error: import reads records from stdin or a file, so it has to be given as arguments\n")
+
+	case "query", "new":
+		err = query(db, mod, op.args, os.Stdout, op.action == "new")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s error: %s\n", op.action, err)
+			return
+		}
+
+	case "related":
+		if op.arg == "" {
+			fmt.Fprintf(os.Stderr, "related error: nothing to find related records for\n")
+			return
+		}
+		vals, err := related(db, mod.names()[0], op.arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "related error: %s\n", err)
+			return
+		}
+		if len(vals) == 0 {
+			fmt.Printf("No related %s found.\n", mod.names()[1])
+			return
+		}
+		for _, v := range vals {
+			fmt.Println(v)
+		}
+
 	default:
 		fmt.Fprintf(os.Stderr, "unknown action: %s\n", op.action)
 	}
 }
 
 type module interface {
-	// meta
+	// meta; the first name is the singular, the second the plural
 	names() []string
-	setDB(*sql.DB)
-	initModule() error
+	setDB(dbtx)
+	migrations() []migration
 
 	// change
 	Add(string) error
@@ -126,9 +219,14 @@ type module interface {
 
 	// read
 	All() ([]string, error)
+
+	// query; find returns the id of the record that an
+	// add or delete argument refers to
+	schema() schema
+	find(string) (int64, error)
 }
 
-func getModule(o op, db *sql.DB) (module, error) {
+func getModule(o op, db dbtx) (module, error) {
 
 	for _, m := range modules {
 		for _, name := range m.names() {
@@ -142,39 +240,31 @@ func getModule(o op, db *sql.DB) (module, error) {
 	return nil, fmt.Errorf("no such module: %s", o.typ)
 }
 
+// An op is one command. The argument is the rest of the line, because
+// some modules take more than one value (e.g. add port 10.0.0.1 443
+// https); args has the same thing split up, for actions with options
 type op struct {
 	action string
 	typ    string
 	arg    string
+	args   []string
 }
 
-func tokenize(in string) (op, error) {
-	t := strings.Fields(in)
-	if len(t) < 2 {
-		return op{}, fmt.Errorf("not enough tokens in '%s'", in)
-	}
-
-	arg := ""
-	if len(t) > 2 {
-		arg = t[2]
-	}
-
+func newOp(t []string) op {
 	return op{
 		action: strings.ToLower(t[0]),
 		typ:    strings.ToLower(t[1]),
-		arg:    arg,
-	}, nil
+		arg:    strings.Join(t[2:], " "),
+		args:   t[2:],
+	}
 }
 
-func initModules(db *sql.DB) error {
-
-	for _, m := range modules {
-		m.setDB(db)
-		err := m.initModule()
-		if err != nil {
-			return err
-		}
+// tokenize splits a line into the action, the type, and the argument
+func tokenize(in string) (op, error) {
+	t := strings.Fields(in)
+	if len(t) < 2 {
+		return op{}, fmt.Errorf("not enough tokens in '%s'", in)
 	}
 
-	return nil
+	return newOp(t), nil
 }
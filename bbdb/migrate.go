@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// dbtx is what the modules use to talk to the database: either the
+// database itself or a transaction, so that lots of commands can be
+// batched into one transaction, which is much faster than committing
+// each of them on its own
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// A migration is one step in making or changing a table. Each module
+// has a list of them, and the database records how many of each list
+// have been run, so when a module gains a column it's a matter of
+// adding a migration to the end of its list and existing databases
+// are upgraded the next time they're opened. Migrations must never be
+// changed or reordered once they've been released
+type migration func(dbtx) error
+
+func execMigration(q string) migration {
+	return func(tx dbtx) error {
+		_, err := tx.Exec(q)
+		return err
+	}
+}
+
+// provenanceMigration adds the provenance columns. Databases made
+// before migrations were versioned may already have them, so unlike
+// most migrations it's fine to run it more than once
+func provenanceMigration(table string) migration {
+	return func(tx dbtx) error {
+		return addProvenance(tx, table)
+	}
+}
+
+// migrate brings the tables up to date. Databases made before
+// migrations were versioned start at version 0 for everything; the
+// first migrations in each list are all safe to run on tables that
+// already exist, so they upgrade like any other
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`
+		create table if not exists schema_versions (
+			name text primary key,
+			version integer not null
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	err = migrateList(db, "tags", tagMigrations)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range modules {
+		err := migrateList(db, m.names()[1], m.migrations())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateList runs the migrations in steps that haven't been run yet,
+// each in a transaction along with the change to the version, so a
+// failure leaves the database as it was before that step
+func migrateList(db *sql.DB, name string, steps []migration) error {
+	var version int
+	err := db.QueryRow("select version from schema_versions where name = ?", name).Scan(&version)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if version > len(steps) {
+		return fmt.Errorf("%s is at version %d, but this bbdb only knows up to %d; is it out of date?", name, version, len(steps))
+	}
+
+	for ; version < len(steps); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		err = steps[version](tx)
+		if err == nil {
+			_, err = tx.Exec(`
+				insert into schema_versions (name, version) values(?, ?)
+				on conflict (name) do update set version = excluded.version
+			`, name, version+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrating %s to version %d: %w", name, version+1, err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// baselineSchema is the only table bbdb made before it had migrations
+const baselineSchema = `
+	create table domains (
+		id integer primary key,
+		domain text unique not null
+	)
+`
+
+func TestMigrateBaseline(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "bbdb.db"))
+	if err != nil {
+		t.Fatalf("expected nil error from sql.Open(), have %s", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(baselineSchema)
+	if err == nil {
+		_, err = db.Exec("insert into domains (domain) values ('example.com')")
+	}
+	if err != nil {
+		t.Fatalf("expected nil error making the baseline database, have %s", err)
+	}
+
+	// the second run should find nothing to do
+	for i := 0; i < 2; i++ {
+		err = migrate(db)
+		if err != nil {
+			t.Fatalf("expected nil error from migrate() run %d, have %s", i+1, err)
+		}
+	}
+
+	rows, err := db.Query("select name from pragma_table_info('domains')")
+	if err != nil {
+		t.Fatalf("expected nil error reading the domains columns, have %s", err)
+	}
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("expected nil error from Scan(), have %s", err)
+		}
+		columns[name] = true
+	}
+	rows.Close()
+
+	for _, c := range []string{"id", "domain", "first_seen", "last_seen", "source", "run_id"} {
+		if !columns[c] {
+			t.Errorf("want column %s in domains, have %v", c, columns)
+		}
+	}
+
+	var domain string
+	err = db.QueryRow("select domain from domains").Scan(&domain)
+	if err != nil || domain != "example.com" {
+		t.Errorf("want example.com kept in domains, have %q (%v)", domain, err)
+	}
+
+	want := map[string]int{"tags": len(tagMigrations)}
+	for _, m := range modules {
+		want[m.names()[1]] = len(m.migrations())
+	}
+	for name, version := range want {
+		var have int
+		err := db.QueryRow("select version from schema_versions where name = ?", name).Scan(&have)
+		if err != nil || have != version {
+			t.Errorf("want version %d for %s, have %d (%v)", version, name, have, err)
+		}
+	}
+
+	// a database from a newer bbdb shouldn't be touched
+	_, err = db.Exec("update schema_versions set version = version + 1 where name = 'domains'")
+	if err != nil {
+		t.Fatalf("expected nil error bumping the domains version, have %s", err)
+	}
+	if err := migrate(db); err == nil {
+		t.Errorf("want error from migrate() for a newer database, have nil")
+	}
+}
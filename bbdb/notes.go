@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// notes are free-form text about a target:
+//
+//	add note example.com login page rate limits after 5 attempts
+//
+// They're listed with their id, which is what delete takes
+type notes struct {
+	db dbtx
+}
+
+func (n *notes) names() []string {
+	return []string{"note", "notes"}
+}
+
+func (n *notes) setDB(db dbtx) {
+	n.db = db
+}
+
+func (n *notes) migrations() []migration {
+	return []migration{
+		execMigration(`
+			create table if not exists notes (
+				id integer primary key,
+				target text not null,
+				note text not null
+			)
+		`),
+		provenanceMigration("notes"),
+	}
+}
+
+func (n *notes) schema() schema {
+	return schema{
+		table:   "notes",
+		columns: []string{"id", "target", "note"},
+		tracked: true,
+		match:   "target",
+	}
+}
+
+func (n *notes) find(arg string) (int64, error) {
+	id, err := parseID(arg)
+	if err != nil {
+		return 0, err
+	}
+	return findID(n.db, arg, "select id from notes where id = ?", id)
+}
+
+func (n *notes) Add(arg string) error {
+	t := strings.Fields(arg)
+	if len(t) < 2 {
+		return errors.New("expected a target and a note")
+	}
+
+	s := newStamp()
+	_, err := n.db.Exec(`
+		insert into notes (target, note, first_seen, last_seen, source, run_id) values(?, ?, ?, ?, ?, ?)
+	`, t[0], strings.Join(t[1:], " "), s.at, s.at, s.source, s.run)
+	return err
+}
+
+func (n *notes) Delete(arg string) error {
+	id, err := parseID(arg)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.db.Exec("delete from notes where id = ?", id)
+	return err
+}
+
+func (n *notes) All() ([]string, error) {
+	rows, err := n.db.Query("select id, target, note from notes")
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var id int64
+		var target, note string
+		err = rows.Scan(&id, &target, &note)
+		if err != nil {
+			return out, err
+		}
+
+		out = append(out, fmt.Sprintf("%d %s %s", id, target, note))
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ports are open ports on a host (a domain or an IP), with the
+// service that's running on them if it's known:
+//
+//	add port 10.0.0.1 443 https
+//	add port example.com 53/udp dns
+type ports struct {
+	db dbtx
+}
+
+func (p *ports) names() []string {
+	return []string{"port", "ports"}
+}
+
+func (p *ports) setDB(db dbtx) {
+	p.db = db
+}
+
+func (p *ports) migrations() []migration {
+	return []migration{
+		execMigration(`
+			create table if not exists ports (
+				id integer primary key,
+				host text not null,
+				port integer not null,
+				proto text not null default 'tcp',
+				service text not null default '',
+				unique (host, port, proto)
+			)
+		`),
+		provenanceMigration("ports"),
+	}
+}
+
+func (p *ports) schema() schema {
+	return schema{
+		table:   "ports",
+		columns: []string{"host", "port", "proto", "service"},
+		tracked: true,
+		match:   "host",
+	}
+}
+
+func (p *ports) find(arg string) (int64, error) {
+	host, port, proto, _, err := parsePort(arg)
+	if err != nil {
+		return 0, err
+	}
+	return findID(p.db, arg, "select id from ports where host = ? and port = ? and proto = ?", host, port, proto)
+}
+
+// Add stores a port, or updates the service on one that's already
+// stored. Adding it again without a service leaves the service alone
+func (p *ports) Add(arg string) error {
+	host, port, proto, service, err := parsePort(arg)
+	if err != nil {
+		return err
+	}
+
+	s := newStamp()
+	_, err = p.db.Exec(`
+		insert into ports (host, port, proto, service, first_seen, last_seen, source, run_id)
+		values(?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict (host, port, proto) do update set
+			service = case when excluded.service != '' then excluded.service else service end,
+			last_seen = excluded.last_seen
+	`, host, port, proto, service, s.at, s.at, s.source, s.run)
+	return err
+}
+
+func (p *ports) Delete(arg string) error {
+	host, port, proto, _, err := parsePort(arg)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.db.Exec("delete from ports where host = ? and port = ? and proto = ?", host, port, proto)
+	return err
+}
+
+func (p *ports) All() ([]string, error) {
+	rows, err := p.db.Query("select host, port, proto, service from ports order by host, port")
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var host, proto, service string
+		var port int
+		err = rows.Scan(&host, &port, &proto, &service)
+		if err != nil {
+			return out, err
+		}
+
+		line := fmt.Sprintf("%s %d/%s", host, port, proto)
+		if service != "" {
+			line += " " + service
+		}
+		out = append(out, line)
+	}
+
+	return out, rows.Err()
+}
+
+// parsePort reads "host port[/proto] [service]". The
+// protocol defaults to tcp
+func parsePort(arg string) (host string, port int, proto, service string, err error) {
+	t := strings.Fields(arg)
+	if len(t) < 2 {
+		return "", 0, "", "", errors.New("expected a host and a port")
+	}
+
+	host = strings.ToLower(t[0])
+	if ip, err := normaliseIP(host); err == nil {
+		host = ip
+	}
+
+	proto = "tcp"
+	num := t[1]
+	if i := strings.Index(num, "/"); i != -1 {
+		num, proto = num[:i], strings.ToLower(num[i+1:])
+	}
+	if proto != "tcp" && proto != "udp" {
+		return "", 0, "", "", fmt.Errorf("invalid protocol: %s", proto)
+	}
+
+	port, err = strconv.Atoi(num)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, "", "", fmt.Errorf("invalid port: %s", t[1])
+	}
+
+	if len(t) > 2 {
+		service = strings.ToLower(strings.Join(t[2:], " "))
+	}
+	return host, port, proto, service, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// current is where the records being added came from, set with the
+// -source and -run flags. Every record keeps the source and run it
+// was first seen in, and when it was first and last seen
+var current struct {
+	source string
+	run    string
+}
+
+// timeFormat is used for first_seen and last_seen. It's always UTC
+// with a fixed number of decimal places, so the timestamps sort in the
+// right order as text, even when one run starts just after another
+const timeFormat = "2006-01-02T15:04:05.000000Z"
+
+// A stamp is the provenance of a record that's being added
+type stamp struct {
+	at     string
+	source string
+	run    string
+}
+
+func newStamp() stamp {
+	return stamp{
+		at:     time.Now().UTC().Format(timeFormat),
+		source: current.source,
+		run:    current.run,
+	}
+}
+
+// provenanceColumns are added to every record table
+var provenanceColumns = []string{"first_seen", "last_seen", "source", "run_id"}
+
+// addProvenance adds the provenance columns to a table if it doesn't
+// have them, which is the case for tables made by older versions of
+// bbdb, and indexes first_seen for the new action and -since
+func addProvenance(db dbtx, table string) error {
+	rows, err := db.Query("select name from pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	have := map[string]bool{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+		have[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range provenanceColumns {
+		if have[c] {
+			continue
+		}
+		_, err := db.Exec(fmt.Sprintf("alter table %s add column %s text not null default ''", table, c))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(fmt.Sprintf("create index if not exists %[1]s_first_seen on %[1]s (first_seen)", table))
+	return err
+}
+
+// recordRun notes the start of the current run the first time
+// something's added. Several invocations of bbdb can share a run
+// by passing the same -run, and the run starts with the first one
+var runRecorded bool
+
+func recordRun(db dbtx) error {
+	if runRecorded {
+		return nil
+	}
+	s := newStamp()
+	_, err := db.Exec("insert or ignore into runs (run, started, source) values(?, ?, ?)", s.run, s.at, s.source)
+	if err != nil {
+		return err
+	}
+	runRecorded = true
+	return nil
+}
+
+// parseSince turns the value of -since or -until into a timestamp to
+// compare first_seen with. It can be a time (RFC3339 or 2006-01-02),
+// an age (90m, 24h, 7d), or the ID of a run, meaning the time it started
+func parseSince(db dbtx, s string) (string, error) {
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			return time.Now().UTC().AddDate(0, 0, -days).Format(timeFormat), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().UTC().Add(-d).Format(timeFormat), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC().Format(timeFormat), nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Format(timeFormat), nil
+	}
+
+	var started string
+	err := db.QueryRow("select started from runs where run = ?", s).Scan(&started)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("'%s' isn't a time, an age or a run", s)
+	}
+	return started, err
+}
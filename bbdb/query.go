@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// driverName is SQLite with a regexp function added, which
+// SQLite doesn't have built in, so that query's -regex can be
+// done in the database rather than on every row in Go
+const driverName = "sqlite3_bbdb"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", matchRegexp, true)
+		},
+	})
+}
+
+// compiled regexes are cached because matchRegexp is
+// called once per row with the same pattern every time
+var regexps sync.Map
+
+// matchRegexp implements "s regexp re", which SQLite calls as regexp(re, s)
+func matchRegexp(re, s string) (bool, error) {
+	v, ok := regexps.Load(re)
+	if !ok {
+		c, err := regexp.Compile(re)
+		if err != nil {
+			return false, err
+		}
+		v, _ = regexps.LoadOrStore(re, c)
+	}
+	return v.(*regexp.Regexp).MatchString(s), nil
+}
+
+// A schema describes a module's table for query
+type schema struct {
+	table string
+
+	// columns are output by query, in order
+	columns []string
+
+	// tracked tables have the provenance columns
+	tracked bool
+
+	// match is the column -suffix and -regex are matched against
+	match string
+}
+
+// query outputs the records of mod's type that match the filters in
+// args. The filtering's done by SQLite and the rows are written out as
+// they're read, so it works on tables that are too big to list with
+// all and filter afterwards. The new action is a query that has to
+// have -since
+func query(db dbtx, mod module, args []string, w io.Writer, isNew bool) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	var suffix, pattern, tags, format, since, until, source, run string
+	var count bool
+	fs.StringVar(&suffix, "suffix", "", "")
+	fs.StringVar(&pattern, "regex", "", "")
+	fs.StringVar(&tags, "tag", "", "")
+	fs.StringVar(&format, "format", "plain", "")
+	fs.BoolVar(&count, "count", false, "")
+	fs.StringVar(&since, "since", "", "")
+	fs.StringVar(&until, "until", "", "")
+	fs.StringVar(&source, "source", "", "")
+	fs.StringVar(&run, "run", "", "")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+	}
+	if format != "plain" && format != "jsonl" && format != "csv" {
+		return fmt.Errorf("unknown format: %s (want plain, jsonl or csv)", format)
+	}
+
+	if isNew && since == "" {
+		return errors.New("new needs -since: a time, an age (e.g. 24h) or a run")
+	}
+
+	s := mod.schema()
+	typ := mod.names()[0]
+
+	if !s.tracked && (since != "" || until != "" || source != "" || run != "") {
+		return fmt.Errorf("%s don't have first-seen times or sources", mod.names()[1])
+	}
+
+	where := []string{}
+	params := []interface{}{}
+
+	// like related, the suffix has to match whole labels, so
+	// example.com matches www.example.com but not notexample.com
+	suffix = strings.TrimLeft(strings.ToLower(suffix), ".")
+	if suffix != "" {
+		where = append(where, fmt.Sprintf("(r.%[1]s = ? or substr(r.%[1]s, -length(?) - 1) = '.' || ?)", s.match))
+		params = append(params, suffix, suffix, suffix)
+	}
+
+	if pattern != "" {
+		// check it here for a better error than SQLite would give
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid -regex: %w", err)
+		}
+		where = append(where, fmt.Sprintf("r.%s regexp ?", s.match))
+		params = append(params, pattern)
+	}
+
+	if since != "" {
+		ts, err := parseSince(db, since)
+		if err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+		where = append(where, "r.first_seen >= ?")
+		params = append(params, ts)
+	}
+
+	if until != "" {
+		ts, err := parseSince(db, until)
+		if err != nil {
+			return fmt.Errorf("invalid -until: %w", err)
+		}
+		where = append(where, "r.first_seen < ?")
+		params = append(params, ts)
+	}
+
+	if source != "" {
+		where = append(where, "r.source = ?")
+		params = append(params, source)
+	}
+
+	if run != "" {
+		where = append(where, "r.run_id = ?")
+		params = append(params, run)
+	}
+
+	for _, t := range strings.Split(strings.ToLower(tags), ",") {
+		if t == "" {
+			continue
+		}
+		where = append(where, "exists (select 1 from tags where tags.type = ? and tags.record_id = r.id and tags.tag = ?)")
+		params = append(params, typ, t)
+	}
+
+	cond := ""
+	if len(where) > 0 {
+		cond = " where " + strings.Join(where, " and ")
+	}
+
+	if count {
+		var n int64
+		err := db.QueryRow("select count(*) from "+s.table+" r"+cond, params...).Scan(&n)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, n)
+		return nil
+	}
+
+	// the provenance goes in JSONL and CSV output,
+	// but would make plain output hard to read
+	columns := s.columns
+	if s.tracked && format != "plain" {
+		columns = append(append([]string{}, s.columns...), provenanceColumns...)
+	}
+
+	cols := make([]string, len(columns))
+	for i, c := range columns {
+		cols[i] = "r." + c
+	}
+	q := fmt.Sprintf(
+		"select %s, (select group_concat(tag, ',') from tags where tags.type = ? and tags.record_id = r.id) from %s r%s order by r.id",
+		strings.Join(cols, ", "), s.table, cond,
+	)
+
+	rows, err := db.Query(q, append([]interface{}{typ}, params...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	out := newRecordWriter(format, columns, w)
+	for rows.Next() {
+		vals := make([]interface{}, len(columns)+1)
+		ptrs := make([]interface{}, len(vals))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return err
+		}
+
+		for i, v := range vals {
+			if b, ok := v.([]byte); ok {
+				vals[i] = string(b)
+			}
+		}
+
+		recordTags := []string{}
+		if t, ok := vals[len(vals)-1].(string); ok && t != "" {
+			recordTags = strings.Split(t, ",")
+		}
+
+		err = out.write(vals[:len(vals)-1], recordTags)
+		if err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return out.flush()
+}
+
+// A recordWriter outputs query results in one of the formats
+type recordWriter struct {
+	format  string
+	columns []string
+	w       *bufio.Writer
+	csv     *csv.Writer
+}
+
+func newRecordWriter(format string, columns []string, w io.Writer) *recordWriter {
+	rw := &recordWriter{format: format, columns: columns, w: bufio.NewWriter(w)}
+	if format == "csv" {
+		rw.csv = csv.NewWriter(rw.w)
+		rw.csv.Write(append(append([]string{}, columns...), "tags"))
+	}
+	return rw
+}
+
+func (rw *recordWriter) write(vals []interface{}, tags []string) error {
+	switch rw.format {
+	case "jsonl":
+		rec := make(map[string]interface{}, len(vals)+1)
+		for i, v := range vals {
+			rec[rw.columns[i]] = v
+		}
+		rec["tags"] = tags
+
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		rw.w.Write(b)
+		return rw.w.WriteByte('\n')
+
+	case "csv":
+		line := make([]string, 0, len(vals)+1)
+		for _, v := range vals {
+			line = append(line, valueString(v))
+		}
+		return rw.csv.Write(append(line, strings.Join(tags, ",")))
+
+	default:
+		// plain output leaves out empty values so
+		// there are no gaps between the others
+		parts := make([]string, 0, len(vals))
+		for _, v := range vals {
+			if s := valueString(v); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		_, err := fmt.Fprintln(rw.w, strings.Join(parts, " "))
+		return err
+	}
+}
+
+func (rw *recordWriter) flush() error {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return rw.w.Flush()
+}
+
+func valueString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// relations link records of different types together, so it's
+// possible to pivot from one asset to another:
+//
+//	add relation www.example.com resolves-to 10.0.0.1
+//	add relation www.example.com points-to example.azureedge.net
+//	add relation https://www.example.com/ belongs-to www.example.com
+//	add relation 3 references https://www.example.com/admin
+//
+// The records at either end are added if they aren't already stored,
+// apart from findings, which have to exist
+type relations struct {
+	db dbtx
+}
+
+// relationKinds are the kinds of relation there can be, and the
+// types of record they go from and to
+var relationKinds = map[string]struct{ from, to string }{
+	"resolves-to": {"domain", "ip"},
+	"points-to":   {"domain", "domain"},
+	"belongs-to":  {"url", "domain"},
+	"references":  {"finding", "url"},
+}
+
+func (r *relations) names() []string {
+	return []string{"relation", "relations"}
+}
+
+func (r *relations) setDB(db dbtx) {
+	r.db = db
+}
+
+func (r *relations) migrations() []migration {
+	return []migration{
+		execMigration(`
+			create table if not exists relations (
+				id integer primary key,
+				from_type text not null,
+				from_value text not null,
+				kind text not null,
+				to_type text not null,
+				to_value text not null,
+				unique (from_value, kind, to_value)
+			);
+			create index if not exists relations_to on relations (to_value)
+		`),
+		provenanceMigration("relations"),
+	}
+}
+
+func (r *relations) schema() schema {
+	return schema{
+		table:   "relations",
+		columns: []string{"from_value", "kind", "to_value"},
+		tracked: true,
+		match:   "from_value",
+	}
+}
+
+func (r *relations) find(arg string) (int64, error) {
+	from, kind, to, err := r.parse(arg)
+	if err != nil {
+		return 0, err
+	}
+	return findID(r.db, arg, "select id from relations where from_value = ? and kind = ? and to_value = ?", from, kind, to)
+}
+
+func (r *relations) Add(arg string) error {
+	from, kind, to, err := r.parse(arg)
+	if err != nil {
+		return err
+	}
+
+	k := relationKinds[kind]
+	for _, end := range []struct{ typ, value string }{{k.from, from}, {k.to, to}} {
+		if err := r.addRecord(end.typ, end.value); err != nil {
+			return err
+		}
+	}
+
+	return addRelation(r.db, k.from, from, kind, k.to, to)
+}
+
+func (r *relations) Delete(arg string) error {
+	from, kind, to, err := r.parse(arg)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec("delete from relations where from_value = ? and kind = ? and to_value = ?", from, kind, to)
+	return err
+}
+
+func (r *relations) All() ([]string, error) {
+	rows, err := r.db.Query("select from_value, kind, to_value from relations order by from_value, kind, to_value")
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var from, kind, to string
+		err = rows.Scan(&from, &kind, &to)
+		if err != nil {
+			return out, err
+		}
+
+		out = append(out, fmt.Sprintf("%s %s %s", from, kind, to))
+	}
+
+	return out, rows.Err()
+}
+
+// parse reads "from kind to", normalising both ends for their types
+func (r *relations) parse(arg string) (string, string, string, error) {
+	t := strings.Fields(arg)
+	if len(t) != 3 {
+		return "", "", "", errors.New("expected 'from kind to', e.g. www.example.com resolves-to 10.0.0.1")
+	}
+
+	kind := strings.ToLower(t[1])
+	k, ok := relationKinds[kind]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown relation: %s (want resolves-to, points-to, belongs-to or references)", t[1])
+	}
+
+	from, err := normaliseValue(k.from, t[0])
+	if err != nil {
+		return "", "", "", err
+	}
+	to, err := normaliseValue(k.to, t[2])
+	if err != nil {
+		return "", "", "", err
+	}
+	return from, kind, to, nil
+}
+
+// addRecord makes sure the record at one end of a relation exists
+func (r *relations) addRecord(typ, value string) error {
+	switch typ {
+	case "domain":
+		return (&domains{db: r.db}).Add(value)
+	case "ip":
+		return (&ips{db: r.db}).Add(value)
+	case "url":
+		return (&urls{db: r.db}).Add(value)
+	case "finding":
+		var id int64
+		err := r.db.QueryRow("select id from findings where id = ?", value).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no such finding: %s", value)
+		}
+		return err
+	}
+	return nil
+}
+
+// normaliseValue puts a value in the form it's stored in for its type
+func normaliseValue(typ, value string) (string, error) {
+	switch typ {
+	case "domain":
+		return normalise(value), nil
+	case "ip":
+		return normaliseIP(value)
+	case "url":
+		return normaliseURL(value)
+	case "finding":
+		id, err := parseID(value)
+		return fmt.Sprint(id), err
+	}
+	return value, nil
+}
+
+func addRelation(db dbtx, fromType, from, kind, toType, to string) error {
+	s := newStamp()
+	_, err := db.Exec(`
+		insert into relations (from_type, from_value, kind, to_type, to_value, first_seen, last_seen, source, run_id)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict (from_value, kind, to_value) do update set last_seen = excluded.last_seen
+	`, fromType, from, kind, toType, to, s.at, s.at, s.source, s.run)
+	return err
+}
+
+// deleteRelations removes a deleted record's relations
+// so nothing's left pointing at it
+func deleteRelations(db dbtx, typ, value string) error {
+	_, err := db.Exec(`
+		delete from relations
+		where (from_type = ?1 and from_value = ?2) or (to_type = ?1 and to_value = ?2)
+	`, typ, value)
+	return err
+}
+
+// related returns the records of type typ that are related to value,
+// in either direction. When value is a domain, records related to any
+// of its subdomains are included too, so "related urls example.com"
+// gives the URLs of every subdomain of example.com, and "related
+// domains 10.0.0.1" gives all the domains that resolve to 10.0.0.1
+func related(db dbtx, typ, value string) ([]string, error) {
+	value = guessNormalise(value)
+
+	rows, err := db.Query(`
+		select to_value from relations
+		where to_type = ?1 and (
+			from_value = ?2 or
+			(from_type = 'domain' and substr(from_value, -length(?2) - 1) = '.' || ?2)
+		)
+		union
+		select from_value from relations
+		where from_type = ?1 and (
+			to_value = ?2 or
+			(to_type = 'domain' and substr(to_value, -length(?2) - 1) = '.' || ?2)
+		)
+		order by 1
+	`, typ, value)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var v string
+		err = rows.Scan(&v)
+		if err != nil {
+			return out, err
+		}
+
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return out, err
+	}
+
+	// finding ids on their own aren't much use
+	if typ == "finding" {
+		f := &findings{db: db}
+		for i, id := range out {
+			line, err := f.get(id)
+			if err != nil {
+				return out, err
+			}
+			out[i] = line
+		}
+	}
+
+	return out, nil
+}
+
+// guessNormalise normalises a value whose type isn't known
+// from what it looks like, so it matches what's stored
+func guessNormalise(value string) string {
+	if ip, err := normaliseIP(value); err == nil {
+		return ip
+	}
+	if strings.Contains(value, "://") {
+		if u, err := normaliseURL(value); err == nil {
+			return u
+		}
+	}
+	return normalise(value)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// responses are what came back from requesting a URL: the status
+// code, the page title and the technologies it looks to be using.
+// The tech is a comma separated list given with tech=, and anything
+// else after the status is the title:
+//
+//	add response https://example.com/ 200 tech=nginx,php Example Domain
+//
+// Only the latest response for each URL is kept
+type responses struct {
+	db dbtx
+}
+
+func (r *responses) names() []string {
+	return []string{"response", "responses"}
+}
+
+func (r *responses) setDB(db dbtx) {
+	r.db = db
+}
+
+func (r *responses) migrations() []migration {
+	return []migration{
+		execMigration(`
+			create table if not exists responses (
+				id integer primary key,
+				url text unique not null,
+				status integer not null,
+				title text not null default '',
+				tech text not null default ''
+			)
+		`),
+		provenanceMigration("responses"),
+	}
+}
+
+func (r *responses) schema() schema {
+	return schema{
+		table:   "responses",
+		columns: []string{"url", "status", "title", "tech"},
+		tracked: true,
+		match:   "url",
+	}
+}
+
+func (r *responses) find(raw string) (int64, error) {
+	raw, err := normaliseURL(raw)
+	if err != nil {
+		return 0, err
+	}
+	return findID(r.db, raw, "select id from responses where url = ?", raw)
+}
+
+func (r *responses) Add(arg string) error {
+	t := strings.Fields(arg)
+	if len(t) < 2 {
+		return errors.New("expected a url and a status code")
+	}
+
+	raw, err := normaliseURL(t[0])
+	if err != nil {
+		return err
+	}
+
+	status, err := strconv.Atoi(t[1])
+	if err != nil || status < 100 || status > 999 {
+		return fmt.Errorf("invalid status code: %s", t[1])
+	}
+
+	tech := ""
+	title := []string{}
+	for _, v := range t[2:] {
+		if strings.HasPrefix(v, "tech=") {
+			tech = strings.ToLower(strings.TrimPrefix(v, "tech="))
+			continue
+		}
+		title = append(title, v)
+	}
+
+	s := newStamp()
+	_, err = r.db.Exec(`
+		insert into responses (url, status, title, tech, first_seen, last_seen, source, run_id)
+		values(?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict (url) do update set
+			status = excluded.status,
+			title = excluded.title,
+			tech = excluded.tech,
+			last_seen = excluded.last_seen
+	`, raw, status, strings.Join(title, " "), tech, s.at, s.at, s.source, s.run)
+	return err
+}
+
+func (r *responses) Delete(raw string) error {
+	if raw == "" {
+		return errors.New("no url provided")
+	}
+	raw, err := normaliseURL(raw)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec("delete from responses where url = ?", raw)
+	return err
+}
+
+func (r *responses) All() ([]string, error) {
+	rows, err := r.db.Query("select url, status, title, tech from responses")
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var raw, title, tech string
+		var status int
+		err = rows.Scan(&raw, &status, &title, &tech)
+		if err != nil {
+			return out, err
+		}
+
+		line := fmt.Sprintf("%s %d", raw, status)
+		if tech != "" {
+			line += fmt.Sprintf(" [%s]", tech)
+		}
+		if title != "" {
+			line += " " + title
+		}
+		out = append(out, line)
+	}
+
+	return out, rows.Err()
+}
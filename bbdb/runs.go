@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// runs are the runs records have been added in, so that the new
+// action can find what's been seen since a run started. They're
+// recorded automatically, named with -run or after the time the
+// run started
+type runs struct {
+	db dbtx
+}
+
+func (r *runs) names() []string {
+	return []string{"run", "runs"}
+}
+
+func (r *runs) setDB(db dbtx) {
+	r.db = db
+}
+
+func (r *runs) migrations() []migration {
+	return []migration{
+		execMigration(`
+			create table if not exists runs (
+				id integer primary key,
+				run text unique not null,
+				started text not null,
+				source text not null default ''
+			)
+		`),
+	}
+}
+
+func (r *runs) schema() schema {
+	return schema{
+		table:   "runs",
+		columns: []string{"run", "started", "source"},
+		match:   "run",
+	}
+}
+
+func (r *runs) find(run string) (int64, error) {
+	return findID(r.db, run, "select id from runs where run = ?", run)
+}
+
+func (r *runs) Add(run string) error {
+	return errors.New("runs are recorded when records are added; use -run to name one")
+}
+
+// Delete forgets a run. The records added in it keep its ID
+func (r *runs) Delete(run string) error {
+	if run == "" {
+		return errors.New("no run provided")
+	}
+
+	_, err := r.db.Exec("delete from runs where run = ?", run)
+	return err
+}
+
+func (r *runs) All() ([]string, error) {
+	rows, err := r.db.Query("select run, started, source from runs order by started")
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var run, started, source string
+		err = rows.Scan(&run, &started, &source)
+		if err != nil {
+			return out, err
+		}
+
+		line := fmt.Sprintf("%s %s", run, started)
+		if source != "" {
+			line += " " + source
+		}
+		out = append(out, line)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// tagMigrations make the tags table. Tags can go on a record of any
+// type, so they're kept by the record's type and id rather than in
+// each module's table
+var tagMigrations = []migration{
+	execMigration(`
+		create table if not exists tags (
+			type text not null,
+			record_id integer not null,
+			tag text not null,
+			unique (type, record_id, tag)
+		)
+	`),
+}
+
+// tag adds tags to a record, or removes them if remove is set. args
+// are the tags, comma separated, followed by the record in the same
+// form delete takes it:
+//
+//	tag domain inscope,wildcard example.com
+//	untag port web 10.0.0.1 443
+func tag(db dbtx, mod module, remove bool, args []string) error {
+	if len(args) < 2 {
+		return errors.New("expected tags and a record")
+	}
+
+	id, err := mod.find(strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+
+	typ := mod.names()[0]
+	for _, t := range strings.Split(strings.ToLower(args[0]), ",") {
+		if t == "" {
+			continue
+		}
+
+		if remove {
+			_, err = db.Exec("delete from tags where type = ? and record_id = ? and tag = ?", typ, id, t)
+		} else {
+			_, err = db.Exec("insert or ignore into tags (type, record_id, tag) values(?, ?, ?)", typ, id, t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteTags(db dbtx, typ string, id int64) error {
+	_, err := db.Exec("delete from tags where type = ? and record_id = ?", typ, id)
+	return err
+}
+
+// findID runs a query for a record's id, for the modules' find methods
+func findID(db dbtx, arg, q string, args ...interface{}) (int64, error) {
+	var id int64
+	err := db.QueryRow(q, args...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("not found: " + arg)
+	}
+	return id, err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+type urls struct {
+	db dbtx
+}
+
+func (u *urls) names() []string {
+	return []string{"url", "urls"}
+}
+
+func (u *urls) setDB(db dbtx) {
+	u.db = db
+}
+
+func (u *urls) migrations() []migration {
+	return []migration{
+		execMigration(`
+			create table if not exists urls (
+				id integer primary key,
+				url text unique not null
+			)
+		`),
+		provenanceMigration("urls"),
+	}
+}
+
+func (u *urls) schema() schema {
+	return schema{
+		table:   "urls",
+		columns: []string{"url"},
+		tracked: true,
+		match:   "url",
+	}
+}
+
+func (u *urls) find(raw string) (int64, error) {
+	raw, err := singleValue("url", raw)
+	if err != nil {
+		return 0, err
+	}
+	raw, err = normaliseURL(raw)
+	if err != nil {
+		return 0, err
+	}
+	return findID(u.db, raw, "select id from urls where url = ?", raw)
+}
+
+func (u *urls) Add(raw string) error {
+	raw, err := singleValue("url", raw)
+	if err != nil {
+		return err
+	}
+	raw, err = normaliseURL(raw)
+	if err != nil {
+		return err
+	}
+
+	s := newStamp()
+	_, err = u.db.Exec(`
+		insert into urls (url, first_seen, last_seen, source, run_id) values(?, ?, ?, ?, ?)
+		on conflict (url) do update set last_seen = excluded.last_seen
+	`, raw, s.at, s.at, s.source, s.run)
+	if err != nil {
+		return err
+	}
+
+	// every URL belongs to its host, so link them straight away
+	// rather than making that something to remember to do
+	host, _ := url.Parse(raw)
+	if net.ParseIP(host.Hostname()) != nil {
+		return nil
+	}
+	return addRelation(u.db, "url", raw, "belongs-to", "domain", host.Hostname())
+}
+
+func (u *urls) Delete(raw string) error {
+	raw, err := singleValue("url", raw)
+	if err != nil {
+		return err
+	}
+	raw, err = normaliseURL(raw)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.db.Exec("delete from urls where url = ?", raw)
+	if err != nil {
+		return err
+	}
+	return deleteRelations(u.db, "url", raw)
+}
+
+func (u *urls) All() ([]string, error) {
+	rows, err := u.db.Query("select url from urls")
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var raw string
+		err = rows.Scan(&raw)
+		if err != nil {
+			return out, err
+		}
+
+		out = append(out, raw)
+	}
+
+	return out, rows.Err()
+}
+
+// normaliseURL lowercases the scheme and host, which aren't case
+// sensitive, so the same URL isn't stored twice. The path and
+// query are left alone because they might be
+func normaliseURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid url: %s", raw)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Severities, from least to most severe
+var severities = []string{"info", "low", "medium", "high", "critical"}
+
+// Finding classes that don't come from the kind of permutation
+const (
+	classWildcard            = "wildcard"
+	classWildcardCredentials = "wildcard-credentials"
+	classParentDomain        = "parent-domain"
+)
+
+// baseSeverity is how severe a reflection of each kind of origin is
+// without and with credentials allowed. Without credentials only
+// public data can be read, so most are low at best
+var baseSeverity = map[string][2]string{
+	kindSelf:                 {"info", "info"},
+	kindArbitrary:            {"low", "critical"},
+	kindNull:                 {"low", "high"},
+	kindPrefix:               {"low", "high"},
+	kindSuffix:               {"low", "high"},
+	kindSpecialChar:          {"low", "high"},
+	kindUnescapedDot:         {"low", "high"},
+	kindTLDSwap:              {"low", "high"},
+	kindSubdomain:            {"info", "medium"},
+	kindScheme:               {"info", "medium"},
+	kindPort:                 {"info", "medium"},
+	classWildcard:            {"info", "info"},
+	classWildcardCredentials: {"low", "low"},
+	classParentDomain:        {"info", "low"},
+}
+
+// A finding is an interesting CORS response to one origin
+type finding struct {
+	Target    string `json:"target"`
+	Origin    string `json:"origin"`
+	Class     string `json:"class"`
+	Severity  string `json:"severity"`
+	Method    string `json:"method"`
+	Preflight bool   `json:"preflight"`
+
+	ACAO       string `json:"acao"`
+	ACAC       string `json:"acac"`
+	ACAM       string `json:"acam,omitempty"`
+	ACAH       string `json:"acah,omitempty"`
+	ACEH       string `json:"aceh,omitempty"`
+	MaxAge     string `json:"max_age,omitempty"`
+	Vary       string `json:"vary"`
+	VaryOrigin bool   `json:"vary_origin"`
+}
+
+// classify works out whether the response to a permutation is
+// interesting, and if it is what class and severity it is
+func classify(target string, p permutation, h corsHeaders) (finding, bool) {
+	f := finding{
+		Target:     target,
+		Origin:     p.origin,
+		Method:     "GET",
+		ACAO:       h.acao,
+		ACAC:       h.acac,
+		ACAM:       h.acam,
+		ACAH:       h.acah,
+		ACEH:       h.aceh,
+		MaxAge:     h.maxAge,
+		Vary:       h.vary,
+		VaryOrigin: h.varyOrigin,
+	}
+
+	switch {
+	case h.acao == "":
+		return f, false
+
+	case h.acao == "*":
+		// browsers won't send credentials to a wildcard, but
+		// it shows someone tried to make that work
+		f.Class = classWildcard
+		if h.acac == "true" {
+			f.Class = classWildcardCredentials
+		}
+
+	case h.acao == p.origin:
+		f.Class = p.kind
+
+	default:
+		// Check if ACAO is a less specific TLD+1 of the tested origin
+		// e.g. Origin: foo.bar.example.com, ACAO: example.com
+		originTld, err := getTldPlusOne(p.origin)
+		if err != nil {
+			return f, false
+		}
+		acaoTld, err := getTldPlusOne(h.acao)
+		if err != nil || acaoTld != originTld {
+			return f, false
+		}
+		f.Class = classParentDomain
+	}
+
+	sev := baseSeverity[f.Class]
+	f.Severity = sev[0]
+	if h.acac == "true" {
+		f.Severity = sev[1]
+	}
+
+	return f, true
+}
+
+// raiseSeverity returns the next severity up from s
+func raiseSeverity(s string) string {
+	for i, sev := range severities {
+		if sev == s && i < len(severities)-1 {
+			return severities[i+1]
+		}
+	}
+	return s
+}
+
+// severityAtLeast reports whether s is at least as severe as min
+func severityAtLeast(s, min string) bool {
+	rank := func(s string) int {
+		for i, sev := range severities {
+			if sev == s {
+				return i
+			}
+		}
+		return -1
+	}
+	return rank(s) >= rank(min)
+}
+
+// output settings, set by flags in main
+var jsonOutput bool
+var minSeverity = "info"
+
+var outputMu sync.Mutex
+
+// report prints a finding, as JSON or as a line of text
+func report(f finding) {
+	if !severityAtLeast(f.Severity, minSeverity) {
+		return
+	}
+
+	var out string
+	if jsonOutput {
+		// origins and headers are easier to read without & etc
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(f); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding finding for %s: %v\n", f.Target, err)
+			return
+		}
+		out = strings.TrimSuffix(buf.String(), "\n")
+	} else {
+		out = f.String()
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	fmt.Println(out)
+}
+
+func (f finding) String() string {
+	out := fmt.Sprintf("[%s] %s | ", f.Severity, f.Class)
+	if f.Preflight {
+		out += fmt.Sprintf("Preflight: %s | ", f.Method)
+	}
+	out += fmt.Sprintf("Target: %s | Origin: %s | ACAO: %s | ACAC: %s", f.Target, f.Origin, f.ACAO, f.ACAC)
+
+	if f.Preflight {
+		out += fmt.Sprintf(" | ACAM: %s | ACAH: %s", f.ACAM, f.ACAH)
+	}
+	if f.ACEH != "" {
+		out += " | ACEH: " + f.ACEH
+	}
+	if f.MaxAge != "" {
+		out += " | Max-Age: " + f.MaxAge
+	}
+	return out + fmt.Sprintf(" | Vary Origin: %t", f.VaryOrigin)
+}
@@ -1,3 +1,5 @@
 module github.com/0x1Jar/new-hacks/cors-blimey
 
-go 1.16 // Or a newer version if preferred
+go 1.23.0 // Or a newer version if preferred
+
+require golang.org/x/net v0.39.0
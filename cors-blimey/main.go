@@ -3,13 +3,13 @@ package main
 import (
 	"bufio"
 	"crypto/tls"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
-	"flag"
 	"os"
 	"strings" // Added strings import
 	"sync"
@@ -18,10 +18,24 @@ import (
 
 const defaultConcurrency = 20
 const defaultTimeout = 10 // seconds
+const defaultMethods = "PUT,DELETE,PATCH"
+const defaultRequestHeaders = "Authorization,Content-Type,X-Requested-With"
+
+// options for preflight testing, set by flags in main
+var preflight bool
+var preflightMethods []string
+var preflightHeaders string
 
 func main() {
 	concurrency := flag.Int("c", defaultConcurrency, "Concurrency level")
 	timeout := flag.Int("t", defaultTimeout, "HTTP client timeout in seconds")
+	flag.BoolVar(&preflight, "preflight", false, "Also send preflight (OPTIONS) requests for each origin")
+	methods := flag.String("methods", defaultMethods, "Comma separated methods to ask for in preflight requests")
+	flag.StringVar(&preflightHeaders, "request-headers", defaultRequestHeaders, "Comma separated headers to ask for in preflight requests")
+	flag.StringVar(&attackerDomain, "attacker", defaultAttackerDomain, "Attacker controlled domain to use in origin permutations")
+	tlds := flag.String("tlds", defaultSwapTLDs, "Comma separated TLDs to swap in for the target's TLD")
+	flag.BoolVar(&jsonOutput, "json", false, "Output findings as JSON lines")
+	flag.StringVar(&minSeverity, "severity", "info", "Only output findings of at least this severity (info, low, medium, high, critical)")
 	// TODO: Add flag for custom origins list file
 	// TODO: Add flag for custom patterns list file
 
@@ -33,6 +47,14 @@ func main() {
 	}
 	flag.Parse()
 
+	if !severityAtLeast(minSeverity, "info") {
+		fmt.Fprintf(os.Stderr, "Unknown severity %s\n", minSeverity)
+		os.Exit(1)
+	}
+
+	preflightMethods = splitList(*methods)
+	swapTLDs = splitList(*tlds)
+
 	urls := make(chan string)
 	var wg sync.WaitGroup
 
@@ -59,8 +81,8 @@ func main() {
 
 func getClient(timeout time.Duration) *http.Client {
 	tr := &http.Transport{
-		MaxIdleConns:    30, // TODO: Make configurable?
-		IdleConnTimeout: time.Second, // TODO: Make configurable?
+		MaxIdleConns:    30,                                    // TODO: Make configurable?
+		IdleConnTimeout: time.Second,                           // TODO: Make configurable?
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // TODO: Make configurable?
 		DialContext: (&net.Dialer{
 			Timeout:   timeout,
@@ -91,7 +113,6 @@ func testOrigins(c *http.Client, targetURL string) {
 		return
 	}
 
-
 	permutations, err := getPermutations(targetURL)
 
 	if err != nil {
@@ -99,153 +120,195 @@ func testOrigins(c *http.Client, targetURL string) {
 		return
 	}
 
-	for _, origin := range permutations {
-		req, err := http.NewRequest("GET", targetURL, nil)
+	wildcardSeen := false
+	wildcardMethods := make(map[string]bool)
+	for _, p := range permutations {
+		h, err := corsRequest(c, "GET", targetURL, p.origin, "", "")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating request for %s with origin %s: %v\n", targetURL, origin, err)
+			// fmt.Fprintf(os.Stderr, "Error requesting %s with origin %s: %v\n", targetURL, p.origin, err)
 			continue // Try next origin
 		}
-		req.Header.Set("Origin", origin)
-		// Add other common headers that might influence CORS behavior?
-		// req.Header.Set("User-Agent", "CORSBlimeyScanner/1.0")
 
-		resp, err := c.Do(req)
-		if err != nil {
-			// fmt.Fprintf(os.Stderr, "Error requesting %s with origin %s: %v\n", targetURL, origin, err)
-			if resp != nil && resp.Body != nil {
-				io.Copy(ioutil.Discard, resp.Body)
-				resp.Body.Close()
-			}
-			continue // Try next origin
+		f, ok := classify(targetURL, p, h)
+
+		// a wildcard is the same whatever the origin,
+		// so there's no point reporting it every time
+		if ok && h.acao == "*" {
+			ok = !wildcardSeen
+			wildcardSeen = true
 		}
 
-		acao := resp.Header.Get("Access-Control-Allow-Origin")
-		acac := resp.Header.Get("Access-Control-Allow-Credentials") // true or omitted
-
-		// Close body to allow connection reuse
-		io.Copy(ioutil.Discard, resp.Body)
-		resp.Body.Close()
-
-		// Check for interesting CORS headers
-		if acao != "" { // If ACAO is set at all
-			if acao == origin || acao == "*" {
-				// If ACAO reflects the origin or is a wildcard
-				// and if ACAC is true, it's particularly interesting
-				output := fmt.Sprintf("Target: %s | Origin: %s | ACAO: %s", targetURL, origin, acao)
-				if acac == "true" {
-					output += " | ACAC: true (VULNERABLE!)"
-				} else {
-					output += " | ACAC: " + acac
-				}
-				fmt.Println(output)
-			} else if originTld, err := getTldPlusOne(origin); err == nil {
-				// Check if ACAO is a less specific TLD+1 of the tested origin
-				// e.g. Origin: foo.bar.example.com, ACAO: example.com
-				if acaoTld, errAcao := getTldPlusOne(acao); errAcao == nil && acaoTld == originTld && acao != origin {
-					output := fmt.Sprintf("Target: %s | Origin: %s | ACAO: %s (Potentially interesting parent domain reflection)", targetURL, origin, acao)
-					if acac == "true" {
-						output += " | ACAC: true"
-					}
-					fmt.Println(output)
-				}
-			}
+		if ok {
+			report(f)
+		}
+
+		if preflight {
+			testPreflight(c, targetURL, p, wildcardMethods)
 		}
 	}
 }
 
+// corsHeaders holds the CORS related headers from a response
+type corsHeaders struct {
+	acao   string // Access-Control-Allow-Origin
+	acac   string // Access-Control-Allow-Credentials
+	acam   string // Access-Control-Allow-Methods
+	acah   string // Access-Control-Allow-Headers
+	aceh   string // Access-Control-Expose-Headers
+	maxAge string // Access-Control-Max-Age
+	vary   string
+
+	// varyOrigin is true if the response has Vary: Origin (or Vary: *)
+	// so that caches won't serve one origin's response to another
+	varyOrigin bool
+}
 
-func getPermutations(rawTargetURL string) ([]string, error) {
-	target, err := url.Parse(rawTargetURL)
-	if err != nil {
-		return nil, fmt.Errorf("parsing target URL for permutations %s: %w", rawTargetURL, err)
+// allowsMethod reports whether the Access-Control-Allow-Methods
+// header allows method. A wildcard only counts for requests
+// without credentials
+func (h corsHeaders) allowsMethod(method string) bool {
+	for _, m := range splitList(h.acam) {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+		if m == "*" && h.acac != "true" {
+			return true
+		}
 	}
+	return false
+}
 
-	hostname := target.Hostname()
-	if hostname == "" {
-		return nil, fmt.Errorf("target URL %s has no hostname", rawTargetURL)
+// allowsHeaders reports whether the Access-Control-Allow-Headers
+// header allows all of the comma separated headers. Like with
+// methods, a wildcard only counts for requests without credentials,
+// and browsers never let it cover Authorization
+func (h corsHeaders) allowsHeaders(headers string) bool {
+	allowed := splitList(h.acah)
+	for _, want := range splitList(headers) {
+		ok := false
+		for _, a := range allowed {
+			if strings.EqualFold(a, want) {
+				ok = true
+				break
+			}
+			if a == "*" && h.acac != "true" && !strings.EqualFold(want, "Authorization") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
 	}
+	return true
+}
 
-	// Base set of origins to test
-	permutations := []string{
-		"null", // Common misconfiguration
-		"https://evil.com",
-		"http://evil.com",
-		target.Scheme + "://" + hostname, // Self-reflection
-		target.Scheme + "://sub." + hostname, // Subdomain of target
+// corsRequest sends a request with the given origin and returns the
+// CORS headers from the response. If reqMethod is not empty, it and
+// reqHeaders are sent as Access-Control-Request-* headers
+func corsRequest(c *http.Client, method, targetURL, origin, reqMethod, reqHeaders string) (corsHeaders, error) {
+	req, err := http.NewRequest(method, targetURL, nil)
+	if err != nil {
+		return corsHeaders{}, fmt.Errorf("creating request for %s with origin %s: %w", targetURL, origin, err)
+	}
+	req.Header.Set("Origin", origin)
+	// Add other common headers that might influence CORS behavior?
+	// req.Header.Set("User-Agent", "CORSBlimeyScanner/1.0")
+
+	if reqMethod != "" {
+		req.Header.Set("Access-Control-Request-Method", reqMethod)
+		if reqHeaders != "" {
+			req.Header.Set("Access-Control-Request-Headers", reqHeaders)
+		}
 	}
 
-	// Generate permutations based on the target's hostname
-	// Example: if target is sub.example.com, try example.com, evilsub.example.com etc.
-	// This part can be expanded significantly.
-	// The original patterns were:
-	// "https://%s.evil.com" -> https://targethostname.evil.com
-	// "https://%sevil.com"  -> https://targethostnameevil.com (less common)
-
-	patterns := []string{
-		"https://%s.attacker.com", // Attacker controlled subdomain of attacker.com
-		"http://%s.attacker.com",
-		"https://sub.%s",          // Attacker controlled subdomain of target's domain
-		"http://sub.%s",
-		// "https://%s" + ".attacker.com", // Similar to first one
-		// "https://" + hostname + ".evil.com", // Specific evil domain
+	resp, err := c.Do(req)
+	if err != nil {
+		if resp != nil && resp.Body != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		return corsHeaders{}, err
 	}
 
-	for _, p := range patterns {
-		permutations = append(permutations, fmt.Sprintf(p, hostname))
+	// Close body to allow connection reuse
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	h := corsHeaders{
+		acao:   resp.Header.Get("Access-Control-Allow-Origin"),
+		acac:   resp.Header.Get("Access-Control-Allow-Credentials"), // true or omitted
+		acam:   resp.Header.Get("Access-Control-Allow-Methods"),
+		acah:   resp.Header.Get("Access-Control-Allow-Headers"),
+		aceh:   resp.Header.Get("Access-Control-Expose-Headers"),
+		maxAge: resp.Header.Get("Access-Control-Max-Age"),
 	}
-	
-	// Add permutations based on TLD+1 of the target, if possible
-	tldPlusOne, err := getTldPlusOne(hostname)
-	if err == nil && tldPlusOne != hostname { // only if tldPlusOne is different from full hostname
-		permutations = append(permutations, target.Scheme + "://" + tldPlusOne) // e.g. https://example.com
-		permutations = append(permutations, target.Scheme + "://sub." + tldPlusOne) // e.g. https://sub.example.com
-		permutations = append(permutations, "https://"+tldPlusOne+".attacker.com")
+
+	h.vary = strings.Join(resp.Header.Values("Vary"), ", ")
+	for _, v := range resp.Header.Values("Vary") {
+		for _, f := range splitList(v) {
+			if strings.EqualFold(f, "origin") || f == "*" {
+				h.varyOrigin = true
+			}
+		}
 	}
 
+	return h, nil
+}
+
+// testPreflight sends an OPTIONS request for each of the preflight
+// methods and reports those that the origin is allowed to use. Being
+// allowed to make credentialed requests with a method like PUT or
+// DELETE is more severe than just being able to read responses.
+//
+// A browser only goes on to make the real request if the preflight
+// response allows the origin, the method and the request headers, so
+// anything else isn't reported. Like in testOrigins, a wildcard is the
+// same whatever the origin, so it's only reported once for each method;
+// wildcardMethods holds the ones that have been
+func testPreflight(c *http.Client, targetURL string, p permutation, wildcardMethods map[string]bool) {
+	for _, method := range preflightMethods {
+		h, err := corsRequest(c, "OPTIONS", targetURL, p.origin, method, preflightHeaders)
+		if err != nil {
+			continue
+		}
+
+		if h.acao != p.origin && h.acao != "*" {
+			continue
+		}
+		if !h.allowsMethod(method) || !h.allowsHeaders(preflightHeaders) {
+			continue
+		}
 
-	// TODO: Implement the idea from README:
-	// "i have also seen stuff like they don't allow target.com.evil.com or anything after the first .
-	// but if you put target.anothertld it works. Then you just purcahse that domain and you are set to go"
-	// This would involve trying to replace the TLD of the target with common other TLDs.
-	// e.g., if target is example.com, try example.net, example.org, example.co.uk etc.
-	// This requires a list of TLDs and careful construction.
+		f, ok := classify(targetURL, p, h)
+		if !ok {
+			continue
+		}
 
-	return uniqueStrings(permutations), nil
-}
+		if h.acao == "*" {
+			if wildcardMethods[method] {
+				continue
+			}
+			wildcardMethods[method] = true
+		}
 
-// getTldPlusOne attempts to get the top-level domain plus one more label.
-// e.g., "sub.example.co.uk" -> "example.co.uk", "example.com" -> "example.com"
-// This is a simplified approach and might not cover all edge cases perfectly.
-func getTldPlusOne(hostname string) (string, error) {
-	parts := strings.Split(hostname, ".")
-	if len(parts) < 2 {
-		return "", fmt.Errorf("hostname %s has too few parts to determine TLD+1", hostname)
-	}
-	// A very basic heuristic: assume the last two parts are TLD+1 for common cases like .com, .org
-	// and last three for common ccSLDs like .co.uk, .com.au. This is not robust.
-	// For simplicity here, we'll just take the last two parts if there are more than two.
-	// If only two, it's already TLD+1 (e.g. example.com)
-	if len(parts) == 2 {
-		return hostname, nil
-	}
-	// This is a naive assumption for TLDs like .co.uk, .com.au etc.
-	// A proper solution would use a public suffix list.
-	if len(parts) > 2 && (parts[len(parts)-2] == "co" || parts[len(parts)-2] == "com" || parts[len(parts)-2] == "org" || parts[len(parts)-2] == "net" || parts[len(parts)-2] == "gov" || parts[len(parts)-2] == "ac") {
-		if len(parts) >=3 {
-			return strings.Join(parts[len(parts)-3:], "."), nil
-		}
-	}
-	return strings.Join(parts[len(parts)-2:], "."), nil
+		f.Method = method
+		f.Preflight = true
+		if h.acac == "true" && h.acao != "*" {
+			f.Severity = raiseSeverity(f.Severity)
+		}
+		report(f)
+	}
 }
 
-func uniqueStrings(input []string) []string {
-    seen := make(map[string]bool)
-    result := []string{}
-    for _, val := range input {
-        if _, ok := seen[val]; !ok {
-            seen[val] = true
-            result = append(result, val)
-        }
-    }
-    return result
+// splitList splits a comma separated header value or flag into its parts
+func splitList(s string) []string {
+	out := make([]string, 0)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const defaultAttackerDomain = "evil.com"
+const defaultSwapTLDs = "com,net,org,io,co,info,xyz,co.uk"
+
+// the domain an attacker controls, and the TLDs they might buy the
+// target's name under; both are set by flags in main
+var attackerDomain = defaultAttackerDomain
+var swapTLDs = splitList(defaultSwapTLDs)
+
+// specialChars are put between the target's hostname and the attacker's
+// domain (e.g. target.com_.evil.com). Some browsers (notably Safari)
+// will send these in an Origin, and origin checks that only look for
+// the target's hostname followed by a non-word character let them through
+var specialChars = []string{"_", "-", "`", "!", "$", "&", "'", "(", ")", "*", "+", ",", ";", "=", "~", "{", "}", "|", "^"}
+
+// arbitraryPort is used to see if the port is ignored when checking the origin
+const arbitraryPort = "1337"
+
+// The kinds of origin permutation, which say what sort of
+// mistake in the origin check a reflection of them shows
+const (
+	kindSelf         = "self"
+	kindArbitrary    = "arbitrary-origin"
+	kindNull         = "null-origin"
+	kindScheme       = "insecure-scheme"
+	kindPort         = "port-ignored"
+	kindSubdomain    = "subdomain-trust"
+	kindSuffix       = "suffix-bypass"
+	kindPrefix       = "prefix-bypass"
+	kindSpecialChar  = "special-char-bypass"
+	kindUnescapedDot = "unescaped-dot-bypass"
+	kindTLDSwap      = "tld-swap-bypass"
+)
+
+// A permutation is an origin to test, along with the kind of
+// origin check it's trying to get past
+type permutation struct {
+	origin string
+	kind   string
+}
+
+func getPermutations(rawTargetURL string) ([]permutation, error) {
+	target, err := url.Parse(rawTargetURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing target URL for permutations %s: %w", rawTargetURL, err)
+	}
+
+	hostname := target.Hostname()
+	if hostname == "" {
+		return nil, fmt.Errorf("target URL %s has no hostname", rawTargetURL)
+	}
+
+	// trusting the http origin of an https site lets a man-in-the-middle
+	// read responses. The other way round it's no worse than trusting
+	// the site itself, or its subdomain
+	scheme := target.Scheme
+	otherScheme := "https"
+	selfKind, subdomainKind := kindSelf, kindSubdomain
+	if scheme == "https" {
+		otherScheme = "http"
+		selfKind, subdomainKind = kindScheme, kindScheme
+	}
+
+	permutations := make([]permutation, 0)
+	add := func(kind, s, host string) {
+		permutations = append(permutations, permutation{s + "://" + host, kind})
+	}
+
+	// Base set of origins to test
+	permutations = append(permutations, permutation{"null", kindNull}) // Common misconfiguration
+	add(kindArbitrary, "https", attackerDomain)
+	add(kindArbitrary, "http", attackerDomain)
+	add(kindSelf, scheme, hostname)    // Self-reflection
+	add(kindSelf, scheme, target.Host) // Self-reflection, with the port if there is one
+
+	// scheme and port variations
+	add(selfKind, otherScheme, hostname)
+	add(kindPort, scheme, net.JoinHostPort(hostname, arbitraryPort))
+
+	// Subdomain of the target, which an XSS or takeover would give us
+	add(kindSubdomain, scheme, "sub."+hostname)
+	add(subdomainKind, otherScheme, "sub."+hostname)
+
+	// Suffix bypass: the check only looks at the start of the origin
+	add(kindSuffix, scheme, hostname+"."+attackerDomain)
+
+	// Special characters between the target and the attacker's domain
+	for _, c := range specialChars {
+		add(kindSpecialChar, scheme, hostname+c+"."+attackerDomain)
+	}
+
+	// The rest need the registrable domain, which IP addresses don't have
+	registrable, err := getTldPlusOne(hostname)
+	if err != nil || net.ParseIP(hostname) != nil {
+		return uniquePermutations(permutations), nil
+	}
+
+	suffix, _ := publicsuffix.PublicSuffix(registrable)
+	label := strings.TrimSuffix(registrable, "."+suffix)
+	subdomain := strings.TrimSuffix(strings.TrimSuffix(hostname, registrable), ".")
+
+	attackerLabel := strings.Split(attackerDomain, ".")[0]
+
+	if registrable != hostname {
+		add(kindSubdomain, scheme, registrable)                 // e.g. https://example.com
+		add(kindSubdomain, scheme, "sub."+registrable)          // e.g. https://sub.example.com
+		add(kindSuffix, scheme, registrable+"."+attackerDomain) // e.g. https://example.com.evil.com
+	}
+
+	// Prefix bypass: the check only looks at the end of the origin, so
+	// any domain ending in the target's name (e.g. eviltarget.com) works
+	add(kindPrefix, scheme, attackerLabel+registrable)
+
+	// Unescaped dots: a regex like ^https://api.target.com$ also
+	// matches apixtarget.com, which anyone can register. Only the
+	// replacements that result in a new registrable domain are useful
+	for i, c := range hostname {
+		if c != '.' {
+			continue
+		}
+
+		// names under made up TLDs (e.g. target.coxuk) only match the
+		// list's catch-all rule, and can't actually be registered
+		candidate := hostname[:i] + "x" + hostname[i+1:]
+		if _, icann := publicsuffix.PublicSuffix(candidate); !icann {
+			continue
+		}
+
+		if r, err := getTldPlusOne(candidate); err == nil && r != registrable {
+			add(kindUnescapedDot, scheme, candidate)
+		}
+	}
+
+	// TLD swaps: the check allows target.anything, so buy target.net
+	for _, tld := range swapTLDs {
+		tld = strings.TrimPrefix(tld, ".")
+		if tld == suffix {
+			continue
+		}
+
+		swapped := label + "." + tld
+		add(kindTLDSwap, scheme, swapped)
+		if subdomain != "" {
+			add(kindTLDSwap, scheme, subdomain+"."+swapped)
+		}
+	}
+
+	return uniquePermutations(permutations), nil
+}
+
+// getTldPlusOne returns the registrable domain (the public suffix plus
+// one more label) for a hostname or origin, using the public suffix list.
+// e.g., "sub.example.co.uk" -> "example.co.uk", "https://example.com" -> "example.com"
+func getTldPlusOne(hostname string) (string, error) {
+	if strings.Contains(hostname, "://") {
+		u, err := url.Parse(hostname)
+		if err != nil {
+			return "", err
+		}
+		hostname = u.Hostname()
+	}
+
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+
+	r, err := publicsuffix.EffectiveTLDPlusOne(hostname)
+	if err != nil {
+		return "", fmt.Errorf("determining TLD+1 for %s: %w", hostname, err)
+	}
+	return r, nil
+}
+
+// uniquePermutations removes repeated origins, keeping the first
+func uniquePermutations(input []permutation) []permutation {
+	seen := make(map[string]bool)
+	result := []permutation{}
+	for _, p := range input {
+		if _, ok := seen[p.origin]; !ok {
+			seen[p.origin] = true
+			result = append(result, p)
+		}
+	}
+	return result
+}
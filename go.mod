@@ -2,14 +2,16 @@ module hacks
 
 go 1.24.3
 
+require golang.org/x/net v0.39.0
+
 require (
 	github.com/machinebox/graphql v0.2.2 // indirect
 	github.com/mattn/go-sqlite3 v1.14.28 // indirect
 	github.com/miekg/dns v1.1.66 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/mod v0.24.0 // indirect
-	golang.org/x/net v0.39.0 // indirect
 	golang.org/x/sync v0.13.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
+	golang.org/x/text v0.24.0 // indirect
 	golang.org/x/tools v0.32.0 // indirect
 )
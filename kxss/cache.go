@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// requestCache keeps responses by URL so that the same URL is only
+// requested once, even when several workers (or several stages of
+// the pipeline) want it at the same time. The main saving is between
+// the append check and the character check: with -batch they request
+// the same URL, so the character check is answered from the cache.
+// The oldest entries are thrown away once the responses add up to
+// more than max bytes
+type requestCache struct {
+	sync.Mutex
+	max     int
+	used    int
+	entries map[string]*cacheEntry
+	order   []*cacheEntry
+}
+
+type cacheEntry struct {
+	key  string
+	size int
+	done chan struct{}
+	resp response
+	err  error
+}
+
+func newRequestCache(max int) *requestCache {
+	return &requestCache{
+		max:     max,
+		entries: make(map[string]*cacheEntry),
+		order:   make([]*cacheEntry, 0),
+	}
+}
+
+// get returns the cached response for key, calling fn to fill
+// the cache if there isn't one yet. A nil or zero-sized cache
+// just calls fn every time
+func (c *requestCache) get(key string, fn func() (response, error)) (response, error) {
+	if c == nil || c.max <= 0 {
+		return fn()
+	}
+
+	c.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.Unlock()
+		<-e.done
+		return e.resp, e.err
+	}
+
+	e := &cacheEntry{key: key, done: make(chan struct{})}
+	c.entries[key] = e
+	c.order = append(c.order, e)
+	c.Unlock()
+
+	e.resp, e.err = fn()
+
+	// the checks never look at the headers once any
+	// redirects have been followed, so they aren't kept
+	e.resp.header = nil
+	close(e.done)
+
+	c.Lock()
+	if c.entries[key] == e {
+		e.size = e.resp.size()
+		c.used += e.size
+		c.evict()
+	}
+	c.Unlock()
+
+	return e.resp, e.err
+}
+
+// evict throws away the oldest entries until the cache is back
+// under its limit. Anyone already waiting on an evicted entry
+// still has a pointer to it, so that's fine
+func (c *requestCache) evict() {
+	for c.used > c.max && len(c.order) > 0 {
+		e := c.order[0]
+		c.order = c.order[1:]
+
+		// the key may have been evicted and cached again since
+		if c.entries[e.key] == e {
+			delete(c.entries, e.key)
+			c.used -= e.size
+		}
+	}
+}
+
+var errBudgetExhausted = errors.New("request budget exhausted")
+
+// hostBudget limits the number of requests made to each host
+type hostBudget struct {
+	sync.Mutex
+	max  int
+	used map[string]int
+}
+
+func newHostBudget(max int) *hostBudget {
+	return &hostBudget{
+		max:  max,
+		used: make(map[string]int),
+	}
+}
+
+// take uses up one request for host, returning false if there
+// are none left. A nil budget or one with no max never runs out
+func (b *hostBudget) take(host string) bool {
+	if b == nil || b.max <= 0 {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.used[host] >= b.max {
+		return false
+	}
+
+	b.used[host]++
+	if b.used[host] == b.max {
+		fmt.Fprintf(os.Stderr, "request budget of %d used up for %s, skipping further requests\n", b.max, host)
+	}
+
+	return true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The kinds of response content kxss knows how to tell apart
+const (
+	kindHTML  = "html"
+	kindJSON  = "json"
+	kindJS    = "js"
+	kindXML   = "xml"
+	kindOther = "other"
+)
+
+// parseKinds turns a comma separated list like "html,json"
+// into a set of kinds to check
+func parseKinds(s string) (map[string]bool, error) {
+	out := make(map[string]bool)
+
+	for _, k := range strings.Split(s, ",") {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k == "" {
+			continue
+		}
+
+		switch k {
+		case kindHTML, kindJSON, kindJS, kindXML:
+			out[k] = true
+		default:
+			return out, fmt.Errorf("unknown content type '%s' (want html, json, js or xml)", k)
+		}
+	}
+
+	return out, nil
+}
+
+// declaredKind maps a Content-Type header to a kind. It
+// returns an empty string when there's no header at all
+func declaredKind(ct string) string {
+	ct = strings.ToLower(ct)
+
+	switch {
+	case ct == "":
+		return ""
+	case strings.Contains(ct, "html"):
+		return kindHTML
+	case strings.Contains(ct, "json"):
+		return kindJSON
+	case strings.Contains(ct, "javascript"), strings.Contains(ct, "ecmascript"):
+		return kindJS
+	case strings.Contains(ct, "xml"):
+		return kindXML
+	}
+
+	return kindOther
+}
+
+// JSONP responses look like callback({...});
+var jsonpRe = regexp.MustCompile(`^[\w$.]+\s*\(`)
+
+// bodyKind has a go at working out what kind of content a body
+// is by looking at the start of it. It returns an empty string
+// when it can't tell
+func bodyKind(body string) string {
+	b := strings.TrimSpace(strings.TrimPrefix(body, "\ufeff"))
+	if b == "" {
+		return ""
+	}
+
+	if (b[0] == '{' || b[0] == '[') && json.Valid([]byte(b)) {
+		return kindJSON
+	}
+
+	lower := strings.ToLower(b)
+	if len(lower) > 512 {
+		lower = lower[:512]
+	}
+
+	switch {
+	case strings.HasPrefix(lower, "<!doctype html"),
+		strings.HasPrefix(lower, "<html"),
+		strings.Contains(lower, "<head"),
+		strings.Contains(lower, "<body"):
+		return kindHTML
+	case strings.HasPrefix(lower, "<?xml"):
+		return kindXML
+	case jsonpRe.MatchString(b):
+		return kindJS
+	}
+
+	return ""
+}
+
+// sniffKind works out the kind of a response from its Content-Type
+// header and body. The header wins when there is one, because that's
+// what the browser goes by; mismatch is true when the server says a
+// JSON body is HTML, which makes a JSON reflection exploitable
+func sniffKind(ct, body string) (kind string, mismatch bool) {
+	declared := declaredKind(ct)
+	sniffed := bodyKind(body)
+
+	if declared == kindHTML && sniffed == kindJSON {
+		return kindHTML, true
+	}
+
+	if declared != "" {
+		return declared, false
+	}
+
+	// browsers treat responses with no Content-Type as HTML
+	// if they can't work out anything better
+	if sniffed == "" {
+		return kindHTML, false
+	}
+	return sniffed, false
+}
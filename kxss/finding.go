@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// the number of bytes either side of a reflection to
+// include in a snippet, and the most snippets to keep
+const snippetContext = 24
+const maxSnippets = 5
+
+// reflection describes how a probe value showed up in a response
+type reflection struct {
+	status     int
+	kind       string
+	mismatch   bool
+	count      int
+	inLocation bool
+	snippets   []string
+}
+
+// finding is the structured (-json) output for a reflected parameter
+type finding struct {
+	URL            string   `json:"url"`
+	Param          string   `json:"param"`
+	InjectionPoint string   `json:"injection_point"`
+	Status         int      `json:"status"`
+	ContentType    string   `json:"content_type"`
+	Mismatch       bool     `json:"type_mismatch"`
+	ReflectedIn    []string `json:"reflected_in"`
+	Reflections    int      `json:"reflections"`
+	Allowed        []string `json:"allowed"`
+	Blocked        []string `json:"blocked"`
+	Snippets       []string `json:"snippets"`
+
+	// only set when findings are verified in the browser
+	Verification string   `json:"verification,omitempty"`
+	DOM          []string `json:"dom,omitempty"`
+	ProofURL     string   `json:"proof_url,omitempty"`
+}
+
+func newFinding(c paramCheck) finding {
+	f := finding{
+		URL:            c.url,
+		Param:          c.param,
+		InjectionPoint: "query",
+		Allowed:        make([]string, 0),
+		Blocked:        make([]string, 0),
+		ReflectedIn:    make([]string, 0),
+		Snippets:       make([]string, 0),
+	}
+
+	if c.marker != nil {
+		f.Status = c.marker.status
+		f.ContentType = c.marker.kind
+		f.Mismatch = c.marker.mismatch
+		f.Reflections = c.marker.count
+		f.Snippets = append(f.Snippets, c.marker.snippets...)
+
+		if c.marker.count > 0 {
+			f.ReflectedIn = append(f.ReflectedIn, "body")
+		}
+		if c.marker.inLocation {
+			f.ReflectedIn = append(f.ReflectedIn, "location")
+		}
+	}
+
+	return f
+}
+
+var outputMu sync.Mutex
+
+// writeFinding prints a finding as a single line of JSON. It's
+// called from many workers so the writes are serialised
+func writeFinding(f finding) {
+	// the probe characters and snippets are mostly HTML,
+	// so don't escape it all into unreadable \u003c etc
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(f); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode finding for %s: %s\n", f.URL, err)
+		return
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	os.Stdout.Write(buf.Bytes())
+}
+
+// snippets returns a little context around the first few
+// occurrences of needle in body
+func snippets(body, needle string) []string {
+	out := make([]string, 0)
+	if needle == "" {
+		return out
+	}
+
+	offset := 0
+	for len(out) < maxSnippets {
+		i := strings.Index(body[offset:], needle)
+		if i == -1 {
+			break
+		}
+
+		start := offset + i
+		end := start + len(needle)
+
+		from := start - snippetContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + snippetContext
+		if to > len(body) {
+			to = len(body)
+		}
+
+		// the context boundaries can split multi-byte characters
+		out = append(out, strings.ToValidUTF8(body[from:to], ""))
+		offset = end
+	}
+
+	return out
+}
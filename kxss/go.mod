@@ -1,3 +1,5 @@
 module github.com/0x1Jar/new-hacks/kxss
 
 go 1.16 // Or a newer version if preferred, matching other projects
+
+require github.com/chromedp/chromedp v0.9.1
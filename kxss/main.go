@@ -22,6 +22,14 @@ const defaultUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KH
 type paramCheck struct {
 	url   string
 	param string
+
+	// marker is the result of the append check, carried
+	// through to the final stage for structured output
+	marker *reflection
+
+	// result is the finding so far, passed on to
+	// the verification stage when that's turned on
+	result *finding
 }
 
 var transport = &http.Transport{ // Keep as global or pass to newClient
@@ -35,13 +43,57 @@ var transport = &http.Transport{ // Keep as global or pass to newClient
 
 var httpClient = &http.Client{ // Keep as global or pass to newClient
 	Transport: transport,
+
+	// redirects are followed by fetch (if at all) so
+	// that the Location headers can be checked too
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
 }
 var userAgent string // Global to be set by flag
+var jsonOutput bool
+var followRedirects bool
+
+// contentTypes are the kinds of response (see sniffKind)
+// that are checked for reflections
+var contentTypes = map[string]bool{kindHTML: true}
+
+const maxRedirects = 10
+
+// requests are shared between all the stages through the cache,
+// and limited per host by the budget; both are off unless set up
+// in main
+var cache *requestCache
+var budget *hostBudget
+var batchProbes bool
+
+// appendMarker is appended to the value of each reflected parameter
+// to make sure it's really the parameter that's being reflected
+const appendMarker = "kXssRand0mStr1ng"
+
+// probeChars are a common set of XSS probe characters
+// TODO: Make this list configurable
+var probeChars = []string{"\"", "'", "<", ">", "(", ")", "`", ";", "{", "}"}
 
 func main() {
 	var concurrency int
 	flag.IntVar(&concurrency, "c", defaultConcurrency, "Number of concurrent workers per stage")
 	flag.StringVar(&userAgent, "ua", defaultUserAgent, "User-Agent string for requests")
+	flag.BoolVar(&jsonOutput, "json", false, "Output one JSON finding per parameter (JSON lines)")
+	flag.BoolVar(&followRedirects, "follow", false, "Follow redirects, checking for reflections in Location headers along the way")
+	var types string
+	flag.StringVar(&types, "types", kindHTML, "Comma separated kinds of response to check (html, json, js, xml)")
+	var cacheSize int
+	flag.IntVar(&cacheSize, "cache-size", 64, "Megabytes of responses to keep in the request cache (0 to disable)")
+	var maxPerHost int
+	flag.IntVar(&maxPerHost, "budget", 0, "Maximum number of requests to make to each host (0 for no limit)")
+	flag.BoolVar(&batchProbes, "batch", true, "Test all probe characters in a single request where possible")
+	var verify bool
+	flag.BoolVar(&verify, "verify", false, "Verify findings by loading payloads in headless Chrome")
+	var verifyConcurrency int
+	flag.IntVar(&verifyConcurrency, "verify-c", 5, "Number of concurrent browser tabs for -verify")
+	var verifyTimeout int
+	flag.IntVar(&verifyTimeout, "verify-timeout", 10, "Timeout in seconds for loading each page with -verify")
 	// TODO: Add flags for timeouts, TLS skip verify, etc.
 
 	flag.Usage = func() {
@@ -52,11 +104,16 @@ func main() {
 	}
 	flag.Parse()
 
-
-	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
+	var err error
+	contentTypes, err = parseKinds(types)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
 	}
 
+	cache = newRequestCache(cacheSize * 1024 * 1024)
+	budget = newHostBudget(maxPerHost)
+
 	sc := bufio.NewScanner(os.Stdin)
 
 	initialChecks := make(chan paramCheck, concurrency*2) // Buffer size related to concurrency
@@ -75,42 +132,83 @@ func main() {
 		// }
 
 		for _, param := range reflected {
-			output <- paramCheck{c.url, param}
+			output <- paramCheck{url: c.url, param: param}
 		}
 	})
 
 	charChecks := makePool(appendChecks, concurrency, func(c paramCheck, output chan paramCheck) {
-		// Using a more unique random-like string for append check
-		wasReflected, err := checkAppend(c.url, c.param, "kXssRand0mStr1ng")
+		marker, err := probeMarker(c.url, c.param)
 		if err != nil {
 			// fmt.Fprintf(os.Stderr, "Error from checkAppend for url %s with param %s: %v\n", c.url, c.param, err)
 			return
 		}
 
-		if wasReflected {
+		if marker.count > 0 || marker.inLocation {
 			// fmt.Printf("Confirmed reflection of param %s on %s after append check\n", c.param, c.url) // Verbose
-			output <- paramCheck{c.url, c.param}
+			output <- paramCheck{url: c.url, param: c.param, marker: &marker}
 		}
 	})
 
 	done := makePool(charChecks, concurrency, func(c paramCheck, output chan paramCheck) {
-		// Test a common set of XSS probe characters
-		// TODO: Make this list configurable
-		probeChars := []string{"\"", "'", "<", ">", "(", ")", "`", ";", "{", "}"}
-		for _, char := range probeChars {
-			testPayload := "kXssT3st" + char + "P4yL0ad" // Prefix/suffix to make it more unique
-			wasReflected, err := checkAppend(c.url, c.param, testPayload)
-			if err != nil {
-				// fmt.Fprintf(os.Stderr, "Error from checkAppend for url %s with param %s with char '%s': %v\n", c.url, c.param, char, err)
-				continue
-			}
+		f := newFinding(c)
+
+		if !jsonOutput && f.Mismatch {
+			fmt.Printf("param %s is reflected in JSON served as text/html on %s\n", c.param, c.url)
+		}
+		if !jsonOutput && c.marker != nil && c.marker.inLocation {
+			fmt.Printf("param %s is reflected in a Location header on %s\n", c.param, c.url)
+		}
 
-			if wasReflected {
+		f.Allowed, f.Blocked = checkChars(c.url, c.param, probeChars)
+
+		if !jsonOutput {
+			for _, char := range f.Allowed {
 				fmt.Printf("param %s is reflected and allows %s on %s\n", c.param, char, c.url)
 			}
 		}
+
+		if verify {
+			output <- paramCheck{url: c.url, param: c.param, marker: c.marker, result: &f}
+			return
+		}
+
+		if jsonOutput {
+			writeFinding(f)
+		}
 	})
 
+	if verify {
+		v, stop := newVerifier(time.Duration(verifyTimeout) * time.Second)
+		defer stop()
+
+		done = makePool(done, verifyConcurrency, func(c paramCheck, output chan paramCheck) {
+			f := *c.result
+
+			dom, proof, err := v.verify(c.url, c.param, f.Allowed)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to verify param %s on %s: %s\n", c.param, c.url, err)
+			}
+
+			f.DOM = dom
+			f.ProofURL = proof
+			f.Verification = "potential"
+			if len(dom) > 0 {
+				f.Verification = "confirmed"
+			}
+
+			if jsonOutput {
+				writeFinding(f)
+				return
+			}
+
+			if len(dom) > 0 {
+				fmt.Printf("param %s is confirmed to render (%s) on %s\n", c.param, strings.Join(dom, ", "), proof)
+			} else {
+				fmt.Printf("param %s is a potential reflection (not confirmed in the DOM) on %s\n", c.param, c.url)
+			}
+		})
+	}
+
 	for sc.Scan() {
 		initialChecks <- paramCheck{url: sc.Text()}
 	}
@@ -119,13 +217,116 @@ func main() {
 	<-done
 }
 
-func checkReflected(targetURL string) ([]string, error) {
+// response holds the parts of an HTTP response the checks look at
+type response struct {
+	status int
+	header http.Header
+	body   string
+
+	// kind is the type of content (see sniffKind) and mismatch
+	// is set when the body looks like JSON but the server
+	// claims it's HTML
+	kind     string
+	mismatch bool
+
+	// locations holds any Location headers seen when
+	// following redirects
+	locations []string
+}
 
-	out := make([]string, 0)
+// checkable reports whether the response body is worth looking
+// for reflections in; redirects and unwanted kinds are skipped
+func (r response) checkable() bool {
+	// nope (:
+	if r.status >= 300 && r.status < 400 {
+		return false
+	}
+
+	// also nope
+	return contentTypes[r.kind]
+}
+
+// inBody returns the number of times v appears in the body
+func (r response) inBody(v string) int {
+	if !r.checkable() {
+		return 0
+	}
+	return strings.Count(r.body, v)
+}
+
+// inLocation reports whether v appears in any Location header
+func (r response) inLocation(v string) bool {
+	for _, l := range r.locations {
+		if strings.Contains(l, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r response) contains(v string) bool {
+	return r.inBody(v) > 0 || r.inLocation(v)
+}
+
+// size is roughly how much memory a cached response takes up
+func (r response) size() int {
+	n := len(r.body)
+	for _, l := range r.locations {
+		n += len(l)
+	}
+	return n
+}
 
+// fetch requests targetURL, following redirects if that's turned on.
+// Responses come from the cache where possible
+func fetch(targetURL string) (response, error) {
+	return cache.get(targetURL, func() (response, error) {
+		return fetchUncached(targetURL)
+	})
+}
+
+func fetchUncached(targetURL string) (response, error) {
+	locations := make([]string, 0)
+
+	for hops := 0; ; hops++ {
+		resp, err := get(targetURL)
+		if err != nil {
+			return resp, err
+		}
+
+		if !followRedirects || resp.status < 300 || resp.status >= 400 {
+			resp.locations = locations
+			return resp, nil
+		}
+
+		loc := resp.header.Get("Location")
+		if loc != "" {
+			locations = append(locations, loc)
+		}
+
+		// a Location like javascript:... is worth knowing
+		// about, but it's not something we can request
+		next, err := url.Parse(targetURL)
+		if err == nil {
+			next, err = next.Parse(loc)
+		}
+		if loc == "" || err != nil || hops >= maxRedirects || (next.Scheme != "http" && next.Scheme != "https") {
+			resp.locations = locations
+			return resp, nil
+		}
+
+		targetURL = next.String()
+	}
+}
+
+func get(targetURL string) (response, error) {
 	req, err := http.NewRequest("GET", targetURL, nil)
 	if err != nil {
-		return out, err
+		return response{}, err
+	}
+
+	if !budget.take(req.URL.Host) {
+		return response{}, errBudgetExhausted
 	}
 
 	// temporary. Needs to be an option
@@ -133,35 +334,39 @@ func checkReflected(targetURL string) ([]string, error) {
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return out, err
+		return response{}, err
 	}
 	if resp.Body == nil {
-		// Ensure body is closed even if it's nil to prevent resource leaks with some HTTP client behaviors
-		if resp.Body != nil {
-			resp.Body.Close()
-		}
-		return out, fmt.Errorf("response body is nil")
+		return response{}, fmt.Errorf("response body is nil")
 	}
 	defer resp.Body.Close()
 
 	// always read the full body so we can re-use the tcp connection
 	b, err := io.ReadAll(resp.Body) // Changed ioutil.ReadAll to io.ReadAll
 	if err != nil {
-		return out, err
+		return response{}, err
 	}
 
-	// nope (:
-	if strings.HasPrefix(resp.Status, "3") {
-		return out, nil
-	}
+	body := string(b)
+	kind, mismatch := sniffKind(resp.Header.Get("Content-Type"), body)
+
+	return response{
+		status:   resp.StatusCode,
+		header:   resp.Header,
+		body:     body,
+		kind:     kind,
+		mismatch: mismatch,
+	}, nil
+}
 
-	// also nope
-	ct := resp.Header.Get("Content-Type")
-	if ct != "" && !strings.Contains(ct, "html") {
-		return out, nil
-	}
+func checkReflected(targetURL string) ([]string, error) {
 
-	body := string(b)
+	out := make([]string, 0)
+
+	resp, err := fetch(targetURL)
+	if err != nil {
+		return out, err
+	}
 
 	u, err := url.Parse(targetURL)
 	if err != nil {
@@ -170,7 +375,7 @@ func checkReflected(targetURL string) ([]string, error) {
 
 	for key, vv := range u.Query() {
 		for _, v := range vv {
-			if !strings.Contains(body, v) {
+			if !resp.contains(v) {
 				continue
 			}
 
@@ -182,11 +387,22 @@ func checkReflected(targetURL string) ([]string, error) {
 }
 
 func checkAppend(targetURL, param, suffix string) (bool, error) {
-	u, err := url.Parse(targetURL)
+	r, err := probeAppend(targetURL, param, suffix, suffix)
 	if err != nil {
 		return false, err
 	}
 
+	return r.count > 0 || r.inLocation, nil
+}
+
+// appendParam returns targetURL with suffix appended to the value
+// of param, along with the new value
+func appendParam(targetURL, param, suffix string) (string, string, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return "", "", err
+	}
+
 	qs := u.Query()
 	val := qs.Get(param)
 	//if val == "" {
@@ -197,18 +413,198 @@ func checkAppend(targetURL, param, suffix string) (bool, error) {
 	qs.Set(param, val+suffix)
 	u.RawQuery = qs.Encode()
 
-	reflected, err := checkReflected(u.String())
+	return u.String(), val + suffix, nil
+}
+
+// probeMarker appends appendMarker to the value of param and reports
+// where it was reflected. With -batch the probe characters go after
+// the marker, so that the character check's request is the same as
+// this one and is answered from the cache. If that isn't reflected
+// (the characters might have got the request blocked), the marker
+// is tried on its own
+func probeMarker(targetURL, param string) (reflection, error) {
+	if batchProbes {
+		suffix, _ := batchSuffix(probeChars)
+		r, err := probeAppend(targetURL, param, suffix, appendMarker)
+		if err == nil && (r.count > 0 || r.inLocation) {
+			return r, nil
+		}
+	}
+
+	return probeAppend(targetURL, param, appendMarker, appendMarker)
+}
+
+// probeAppend appends suffix to the value of param, requests the
+// resulting URL and reports where the new value was reflected, up
+// to the end of marker, which is the start of suffix
+func probeAppend(targetURL, param, suffix, marker string) (reflection, error) {
+	probeURL, val, err := appendParam(targetURL, param, suffix)
 	if err != nil {
-		return false, err
+		return reflection{}, err
+	}
+	val = strings.TrimSuffix(val, suffix) + marker
+
+	resp, err := fetch(probeURL)
+	if err != nil {
+		return reflection{}, err
+	}
+
+	r := reflection{
+		status:     resp.status,
+		kind:       resp.kind,
+		mismatch:   resp.mismatch,
+		count:      resp.inBody(val),
+		inLocation: resp.inLocation(val),
+		snippets:   make([]string, 0),
+	}
+
+	if r.count > 0 {
+		r.snippets = snippets(resp.body, val)
+	}
+
+	for _, l := range resp.locations {
+		if strings.Contains(l, val) {
+			r.snippets = append(r.snippets, "Location: "+l)
+		}
+	}
+
+	return r, nil
+}
+
+// checkChars works out which of chars are reflected unchanged in
+// param. Characters whose request failed are in neither list
+func checkChars(targetURL, param string, chars []string) ([]string, []string) {
+	reflected := make(map[string]bool)
+	single := chars
+
+	if batchProbes {
+		allowed, blocked, missing, err := probeBatch(targetURL, param, chars)
+		if err == nil {
+			for _, c := range allowed {
+				reflected[c] = true
+			}
+			for _, c := range blocked {
+				reflected[c] = false
+			}
+			// the batch can't say anything about characters
+			// that were cut off, so they get a request each
+			single = missing
+		}
+	}
+
+	for _, char := range single {
+		testPayload := "kXssT3st" + char + "P4yL0ad" // Prefix/suffix to make it more unique
+		wasReflected, err := checkAppend(targetURL, param, testPayload)
+		if err != nil {
+			// fmt.Fprintf(os.Stderr, "Error from checkAppend for url %s with param %s with char '%s': %v\n", targetURL, param, char, err)
+			continue
+		}
+		reflected[char] = wasReflected
+	}
+
+	allowed := make([]string, 0)
+	blocked := make([]string, 0)
+
+	// keep the results in the order the characters were given
+	for _, c := range chars {
+		r, ok := reflected[c]
+		if !ok {
+			continue
+		}
+		if r {
+			allowed = append(allowed, c)
+		} else {
+			blocked = append(blocked, c)
+		}
+	}
+
+	return allowed, blocked
+}
+
+// probeBatch tests all of chars in one request by wrapping each of
+// them in its own numbered markers. A '<' is always put last so that
+// it can't open a tag that a later '>' closes, which a tag stripper
+// would remove along with everything in between.
+//
+// A segment is only counted as blocked if the number that comes after
+// it was reflected: if it wasn't, the value may have been truncated
+// before the character. Those characters are returned in missing,
+// along with all of them if the start of the batch wasn't reflected
+// at all (e.g. a WAF took exception to it), and they need testing
+// one request at a time
+func probeBatch(targetURL, param string, chars []string) ([]string, []string, []string, error) {
+	allowed := make([]string, 0)
+	blocked := make([]string, 0)
+	missing := make([]string, 0)
+
+	suffix, segments := batchSuffix(chars)
+
+	probeURL, val, err := appendParam(targetURL, param, suffix)
+	if err != nil {
+		return allowed, blocked, missing, err
 	}
 
-	for _, r := range reflected {
-		if r == param {
-			return true, nil
+	resp, err := fetch(probeURL)
+	if err != nil {
+		return allowed, blocked, missing, err
+	}
+
+	start := strings.TrimSuffix(val, suffix) + appendMarker
+	if !resp.contains(start) {
+		return allowed, blocked, append(missing, chars...), nil
+	}
+
+	// keep the results in the order the characters were given
+	for _, c := range chars {
+		seg := segments[c]
+		switch {
+		case resp.contains(seg.value):
+			allowed = append(allowed, c)
+		case resp.contains(seg.next):
+			blocked = append(blocked, c)
+		default:
+			missing = append(missing, c)
 		}
 	}
 
-	return false, nil
+	return allowed, blocked, missing, nil
+}
+
+// A segment is one character's part of a batch probe
+type segment struct {
+	// value is the character wrapped in its numbered markers
+	value string
+	// next is the number that starts whatever comes after it
+	next string
+}
+
+// batchSuffix returns the value probeBatch appends: appendMarker
+// followed by the numbered segments for each of chars, and then the
+// next number on its own so that the last segment has one after it
+func batchSuffix(chars []string) (string, map[string]segment) {
+	ordered := make([]string, 0, len(chars))
+	last := make([]string, 0)
+	for _, c := range chars {
+		if strings.Contains(c, "<") {
+			last = append(last, c)
+			continue
+		}
+		ordered = append(ordered, c)
+	}
+	ordered = append(ordered, last...)
+
+	segments := make(map[string]segment)
+	suffix := appendMarker
+	for i, c := range ordered {
+		seg := segment{
+			value: fmt.Sprintf("kX%d%sXk", i, c),
+			next:  fmt.Sprintf("kX%d", i+1),
+		}
+		segments[c] = seg
+		suffix += seg.value
+	}
+	suffix += fmt.Sprintf("kX%d", len(ordered))
+	return suffix, segments
 }
 
 type workerFunc func(paramCheck, chan paramCheck)
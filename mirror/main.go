@@ -2,7 +2,9 @@ package main
 
 import (
 	"bufio"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
@@ -10,36 +12,92 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
-	// "regexp" // Removed duplicate
+	"sort"
 	"strings"
+	"sync"
 	"time" // For http.Client timeout
 )
 
 const (
-	defaultMinLen    = 4
-	defaultUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/99.0.4844.51 Safari/537.36" // A generic UA
-	defaultTimeout   = 10                                                                                                           // seconds
+	defaultMinLen      = 4
+	defaultUserAgent   = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/99.0.4844.51 Safari/537.36" // A generic UA
+	defaultTimeout     = 10                                                                                                         // seconds
+	defaultConcurrency = 20
+
+	// the number of bytes of context to show either side of a reflection
+	contextLen = 6
 )
 
 var httpClient *http.Client
+var userAgent string
+
+// options shared by all of the workers
+var minLen int
+var seedPaths bool
+var canaryMode bool
+var cookies []string
+
+// lines for one URL are written together so that
+// output from different workers doesn't interleave
+var outputMu sync.Mutex
+
+// A probe is a value that was put into the request, and that
+// we look for in the response. name describes where it was put,
+// e.g. "q=foo", "path[2]=mirror3fa9..." or "cookie:session=mirror1b2c..."
+type probe struct {
+	name  string
+	value string
+}
+
+// A reflection is a single place a probe's value turned up
+type reflection struct {
+	// "response body" or "response header <Name>"
+	location string
+
+	// offset is the byte offset into the body or header value;
+	// line and col are only set for reflections in the body
+	offset int
+	line   int
+	col    int
+
+	context string
+}
+
+func (r reflection) String() string {
+	if r.line > 0 {
+		return fmt.Sprintf("%s at line %d, column %d (offset %d) (...%s...)", r.location, r.line, r.col, r.offset, r.context)
+	}
+	return fmt.Sprintf("%s at offset %d (...%s...)", r.location, r.offset, r.context)
+}
+
+type response struct {
+	header http.Header
+	body   string
+}
 
 func main() {
-	var minLen int
 	flag.IntVar(&minLen, "min-len", defaultMinLen, "Minimum length of reflected value to report")
 
-	var userAgent string
 	flag.StringVar(&userAgent, "ua", defaultUserAgent, "User-Agent string for requests")
 
 	var skipVerify bool
 	flag.BoolVar(&skipVerify, "k", false, "Skip TLS certificate verification (insecure)")
-	
+
 	var timeoutSeconds int
 	flag.IntVar(&timeoutSeconds, "t", defaultTimeout, "Request timeout in seconds")
 
+	flag.BoolVar(&seedPaths, "paths", false, "Also replace each path segment with a canary (one extra request per segment)")
+
+	var cookieNames string
+	flag.StringVar(&cookieNames, "cookies", "", "Comma separated cookie names to send with canary values")
+
+	flag.BoolVar(&canaryMode, "canary", false, "Replace each query string value with a unique canary before requesting (ignores -min-len)")
+
+	var concurrency int
+	flag.IntVar(&concurrency, "c", defaultConcurrency, "Number of URLs to check concurrently")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Detects query string values reflected in HTTP response bodies.\n\n")
+		fmt.Fprintf(os.Stderr, "Detects query string values reflected in HTTP response bodies and headers.\n\n")
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] [url...]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "If no URLs are provided as arguments, URLs are read from stdin.\n\nOptions:\n")
 		flag.PrintDefaults()
@@ -58,6 +116,14 @@ func main() {
 		},
 	}
 
+	cookies = make([]string, 0)
+	for _, c := range strings.Split(cookieNames, ",") {
+		c = strings.TrimSpace(c)
+		if c != "" {
+			cookies = append(cookies, c)
+		}
+	}
+
 	var input io.Reader
 	if flag.NArg() > 0 {
 		input = strings.NewReader(strings.Join(flag.Args(), "\n"))
@@ -68,68 +134,243 @@ func main() {
 
 	sc := bufio.NewScanner(input)
 
+	urls := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rawURL := range urls {
+				check(rawURL)
+			}
+		}()
+	}
+
 	for sc.Scan() {
 		rawURL := sc.Text()
 		if rawURL == "" {
 			continue
 		}
-		u, err := url.Parse(rawURL)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing URL %s: %v\n", rawURL, err)
-			continue
+		urls <- rawURL
+	}
+
+	close(urls)
+	wg.Wait()
+}
+
+// check requests rawURL (and any path seeded variants of it)
+// and prints the reflections it finds
+func check(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing URL %s: %v\n", rawURL, err)
+		return
+	}
+
+	// the query string values are checked as they are (or replaced
+	// with canaries in canary mode) and any cookies are seeded with
+	// canaries in the same request
+	probes := make([]probe, 0)
+	qs := u.Query()
+	for k, vv := range qs {
+		for i, v := range vv {
+			if canaryMode {
+				v = newCanary()
+				vv[i] = v
+			} else if len(v) < minLen {
+				continue
+			}
+			probes = append(probes, probe{fmt.Sprintf("%s=%s", k, v), v})
 		}
+	}
 
-		req, err := http.NewRequest("GET", u.String(), nil)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating request for %s: %v\n", u.String(), err)
-			continue
+	if canaryMode {
+		u.RawQuery = qs.Encode()
+	}
+
+	jar := make([]*http.Cookie, 0, len(cookies))
+	for _, name := range cookies {
+		c := &http.Cookie{Name: name, Value: newCanary()}
+		jar = append(jar, c)
+		probes = append(probes, probe{fmt.Sprintf("cookie:%s=%s", c.Name, c.Value), c.Value})
+	}
+
+	lines := make([]string, 0)
+
+	resp, err := fetch(u, jar)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	} else {
+		lines = append(lines, report(u, resp, probes)...)
+	}
+
+	if seedPaths {
+		// each path segment gets its own request so that
+		// the rest of the path still routes somewhere sensible
+		segments := strings.Split(u.Path, "/")
+		for i, seg := range segments {
+			if seg == "" {
+				continue
+			}
+
+			canary := newCanary()
+			seeded := make([]string, len(segments))
+			copy(seeded, segments)
+			seeded[i] = canary
+
+			su := *u
+			su.Path = strings.Join(seeded, "/")
+			su.RawPath = ""
+
+			resp, err := fetch(&su, nil)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				continue
+			}
+			lines = append(lines, report(&su, resp, []probe{{fmt.Sprintf("path[%d]=%s", i, canary), canary}})...)
 		}
-		req.Header.Set("User-Agent", userAgent)
+	}
 
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error fetching %s: %v\n", u.String(), err)
-			continue
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+}
+
+// report returns an output line for every reflection of each of the probes in resp
+func report(u *url.URL, resp response, probes []probe) []string {
+	out := make([]string, 0)
+	for _, p := range probes {
+		for _, r := range findReflections(resp, p.value) {
+			out = append(out, fmt.Sprintf("%s: '%s' reflected in %s", u, p.name, r))
 		}
-		
-		if resp.Body == nil {
-			if resp.Body != nil { // Should always be true if resp.Body is nil, but good practice
-				resp.Body.Close()
+	}
+	return out
+}
+
+func fetch(u *url.URL, cookies []*http.Cookie) (response, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return response{}, fmt.Errorf("Error creating request for %s: %v", u.String(), err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return response{}, fmt.Errorf("Error fetching %s: %v", u.String(), err)
+	}
+
+	if resp.Body == nil {
+		return response{}, fmt.Errorf("Response body is nil for %s", u.String())
+	}
+
+	b, err := io.ReadAll(resp.Body) // Changed ioutil.ReadAll to io.ReadAll
+	resp.Body.Close()               // Close body immediately after reading
+	if err != nil {
+		return response{}, fmt.Errorf("Error reading body from %s: %v", u.String(), err)
+	}
+
+	return response{header: resp.Header, body: string(b)}, nil
+}
+
+// findReflections returns every occurrence of value in the headers
+// (Location, Set-Cookie, custom headers etc) and body of resp
+func findReflections(resp response, value string) []reflection {
+	out := make([]reflection, 0)
+	if value == "" {
+		return out
+	}
+
+	// map iteration order is random, so sort the
+	// header names to keep the output stable
+	names := make([]string, 0, len(resp.header))
+	for name := range resp.header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, hv := range resp.header[name] {
+			for _, offset := range indexAll(hv, value) {
+				out = append(out, reflection{
+					location: "response header " + name,
+					offset:   offset,
+					context:  context(hv, offset, offset+len(value)),
+				})
 			}
-			fmt.Fprintf(os.Stderr, "Response body is nil for %s\n", u.String())
-			continue
 		}
-		
-		b, err := io.ReadAll(resp.Body) // Changed ioutil.ReadAll to io.ReadAll
-		resp.Body.Close() // Close body immediately after reading
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading body from %s: %v\n", u.String(), err)
-			continue
+	}
+
+	line, lineStart, last := 1, 0, 0
+	for _, offset := range indexAll(resp.body, value) {
+		// count lines from the last match rather than
+		// the start of the body each time
+		line += strings.Count(resp.body[last:offset], "\n")
+		if nl := strings.LastIndex(resp.body[:offset], "\n"); nl != -1 {
+			lineStart = nl + 1
 		}
-		body := string(b)
-
-		for k, vv := range u.Query() {
-			for _, v := range vv {
-				if len(v) < minLen {
-					continue
-				}
-
-				// A fairly shonky way to get a few chars of context either side of the match
-				// but it helps avoid trying to find the locations of all the matches in the
-				// body, and then getting the context on either side, with all the bounds
-				// checking etc that would need to be done for that.
-				re, err := regexp.Compile("(.{0,6}" + regexp.QuoteMeta(v) + ".{0,6})")
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "regexp compile error: %s", err)
-				}
-
-				matches := re.FindAllStringSubmatch(body, -1)
-
-				for _, m := range matches {
-					fmt.Printf("%s: '%s=%s' reflected in response body (...%s...)\n", u, k, v, m[0])
-				}
-			}
+		last = offset
+
+		out = append(out, reflection{
+			location: "response body",
+			offset:   offset,
+			line:     line,
+			col:      offset - lineStart + 1,
+			context:  context(resp.body, offset, offset+len(value)),
+		})
+	}
+
+	return out
+}
 
+// indexAll returns the offset of every non-overlapping occurrence of sub in s
+func indexAll(s, sub string) []int {
+	out := make([]int, 0)
+	for offset := 0; offset <= len(s); {
+		i := strings.Index(s[offset:], sub)
+		if i == -1 {
+			break
 		}
+		out = append(out, offset+i)
+		offset += i + len(sub)
+	}
+	return out
+}
+
+// context returns s[start:end] with a few bytes either side, without
+// going past the start or end of the line so the output stays on one line
+func context(s string, start, end int) string {
+	from := start - contextLen
+	if from < 0 {
+		from = 0
+	}
+	if nl := strings.LastIndex(s[from:start], "\n"); nl != -1 {
+		from += nl + 1
+	}
+
+	to := end + contextLen
+	if to > len(s) {
+		to = len(s)
+	}
+	if nl := strings.Index(s[end:to], "\n"); nl != -1 {
+		to = end + nl
+	}
+
+	return s[from:to]
+}
+
+// newCanary returns a random value that's very unlikely to
+// appear in a response unless it's been reflected
+func newCanary() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		// there's not much hope for anything else if this fails
+		panic(err)
 	}
+	return "mirror" + hex.EncodeToString(b)
 }
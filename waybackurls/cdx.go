@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const cdxEndpoint = "http://web.archive.org/cdx/search/cdx"
+
+// cdxFields are the extra CDX fields that can be asked for with
+// -fields, as well as urlkey and original, which are always requested
+var cdxFields = map[string]bool{
+	"timestamp":  true,
+	"statuscode": true,
+	"mimetype":   true,
+	"digest":     true,
+	"length":     true,
+}
+
+// sourceField can be given in -fields to output the name of the
+// source a URL came from. It's not a CDX field, so it's never fetched
+const sourceField = "source"
+
+// A cdxQuery describes what to ask the CDX API for a domain
+type cdxQuery struct {
+	// subs includes subdomains (*.domain/*) rather than
+	// just the domain itself (domain/*)
+	subs bool
+
+	// fields are output before each URL, in order
+	fields []string
+
+	// from and to are timestamp prefixes (e.g. 2019 or 20190601)
+	from string
+	to   string
+
+	// status and mime are regexes matched against the status code and
+	// mime type by the server. A leading ! inverts the match
+	status string
+	mime   string
+
+	// collapse is passed straight through, e.g. urlkey (one row per URL),
+	// digest (one row per distinct response) or timestamp:8 (one per day)
+	collapse string
+
+	// pageSize is the number of index blocks in each page of
+	// results; zero leaves it up to the server
+	pageSize int
+}
+
+// parseFields checks a comma separated list of CDX field names
+func parseFields(s string) ([]string, error) {
+	out := make([]string, 0)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if !cdxFields[f] && f != sourceField {
+			return nil, fmt.Errorf("unknown field '%s'", f)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// fl returns the fields to fetch. urlkey and original come first; urlkey
+// is used to spot the same URL turning up at the end of one page and
+// the start of the next, and to group captures for -latest
+func (q cdxQuery) fl() []string {
+	out := []string{"urlkey", "original"}
+	for _, f := range q.fields {
+		if f != sourceField {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// values returns the CDX API parameters for domain, without any paging
+func (q cdxQuery) values(domain string) url.Values {
+	target := domain + "/*"
+	if q.subs {
+		target = "*." + target
+	}
+
+	v := url.Values{}
+	v.Set("url", target)
+	v.Set("output", "json")
+	v.Set("fl", strings.Join(q.fl(), ","))
+
+	if q.collapse != "" {
+		v.Set("collapse", q.collapse)
+	}
+	if q.from != "" {
+		v.Set("from", q.from)
+	}
+	if q.to != "" {
+		v.Set("to", q.to)
+	}
+	if q.status != "" {
+		v.Add("filter", cdxFilter("statuscode", q.status))
+	}
+	if q.mime != "" {
+		v.Add("filter", cdxFilter("mimetype", q.mime))
+	}
+	if q.pageSize > 0 {
+		v.Set("pageSize", fmt.Sprint(q.pageSize))
+	}
+
+	return v
+}
+
+// key identifies the query for domain in a checkpoint file
+func (q cdxQuery) key(domain string) string {
+	return q.values(domain).Encode()
+}
+
+// numPagesURL returns the URL that gives the number of pages of results
+func (q cdxQuery) numPagesURL(domain string) string {
+	v := q.values(domain)
+	v.Set("showNumPages", "true")
+	v.Del("output")
+	return cdxEndpoint + "?" + v.Encode()
+}
+
+// pageURL returns the URL for one page (counting from zero) of results
+func (q cdxQuery) pageURL(domain string, page int) string {
+	v := q.values(domain)
+	v.Set("page", fmt.Sprint(page))
+	return cdxEndpoint + "?" + v.Encode()
+}
+
+// cdxFilter turns "200" into "statuscode:200" and "!404" into "!statuscode:404"
+func cdxFilter(field, pattern string) string {
+	if strings.HasPrefix(pattern, "!") {
+		return "!" + field + ":" + pattern[1:]
+	}
+	return field + ":" + pattern
+}
+
+// A capture is one row of CDX output: the URL's key in the
+// index, the original URL and the values of any extra fields.
+// Captures from other sources are made to look the same
+type capture struct {
+	urlkey   string
+	original string
+	fields   map[string]string
+	source   string
+}
+
+// format returns the capture's fields, in the order they were asked
+// for, followed by its URL. Timestamps are converted to RFC3339
+func (c capture) format(fields []string) string {
+	parts := make([]string, 0, len(fields)+1)
+	for _, f := range fields {
+		v := c.fields[f]
+		switch f {
+		case "timestamp":
+			v = formatTimestamp(v)
+		case sourceField:
+			v = c.source
+		}
+		if v == "" {
+			v = "-"
+		}
+		parts = append(parts, v)
+	}
+	parts = append(parts, c.original)
+	return strings.Join(parts, " ")
+}
+
+// formatTimestamp converts a CDX timestamp (20060102150405) to RFC3339,
+// leaving it as it is if it can't be parsed
+func formatTimestamp(ts string) string {
+	t, err := time.Parse("20060102150405", ts)
+	if err != nil {
+		return ts
+	}
+	return t.Format(time.RFC3339)
+}
+
+// parseRows turns a page of CDX JSON output into captures. Each row
+// has the values for fl in order. Pages may start with a header row
+// naming the fields, which is skipped
+func parseRows(rows [][]string, fl []string) []capture {
+	out := make([]capture, 0, len(rows))
+	for i, row := range rows {
+		if i == 0 && len(row) > 0 && row[0] == fl[0] {
+			continue
+		}
+
+		c := capture{fields: make(map[string]string)}
+		for j, v := range row {
+			if j >= len(fl) {
+				break
+			}
+			switch fl[j] {
+			case "urlkey":
+				c.urlkey = v
+			case "original":
+				c.original = v
+			default:
+				c.fields[fl[j]] = v
+			}
+		}
+		if c.original != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// An emitter receives the captures for one domain, a page at a time,
+// and outputs them. The index is sorted by urlkey, so every capture
+// of a URL comes together, possibly split across two pages. That's
+// how -latest can pick the newest capture of each URL without keeping
+// everything in memory, and how captures that have already been
+// collapsed on one page can be dropped when they start the next
+type emitter struct {
+	latest   bool
+	collapse bool
+	out      func(capture)
+
+	// pending is the newest capture so far of the current URL for -latest
+	pending *capture
+	lastKey string
+}
+
+func (e *emitter) add(c capture) {
+	if e.latest {
+		if e.pending != nil && e.pending.urlkey != c.urlkey {
+			e.out(*e.pending)
+			e.pending = nil
+		}
+		if e.pending == nil || c.fields["timestamp"] > e.pending.fields["timestamp"] {
+			e.pending = &c
+		}
+		return
+	}
+
+	if e.collapse && c.urlkey == e.lastKey {
+		return
+	}
+	e.lastKey = c.urlkey
+	e.out(c)
+}
+
+// flush outputs the last URL's capture for -latest
+func (e *emitter) flush() {
+	if e.pending != nil {
+		e.out(*e.pending)
+		e.pending = nil
+	}
+}
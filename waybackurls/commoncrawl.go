@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const ccCollInfo = "https://index.commoncrawl.org/collinfo.json"
+
+// ccCrawls is how many of the most recent Common Crawl crawls to search
+var ccCrawls int
+
+// ccIndexes holds the index API URLs of the most recent crawls. It's
+// only fetched once, however many domains there are
+var ccIndexes struct {
+	once sync.Once
+	urls []string
+	err  error
+}
+
+// getCommonCrawlURLs searches the Common Crawl index of each of the
+// most recent crawls for domain, passing the captures to e
+func getCommonCrawlURLs(domain string, e *emitter) error {
+	ccIndexes.once.Do(func() {
+		ccIndexes.urls, ccIndexes.err = ccIndexURLs(ccCrawls)
+	})
+	if ccIndexes.err != nil {
+		return ccIndexes.err
+	}
+
+	for _, index := range ccIndexes.urls {
+		v := ccValues(domain)
+		key := index + "?" + v.Encode()
+
+		err := fetchPages(key, domain,
+			func() (int, error) { return ccNumPages(index, v) },
+			func(page int) ([]capture, error) { return ccPage(index, v, page) },
+			e,
+		)
+		if err != nil {
+			return fmt.Errorf("%s: %w", index, err)
+		}
+	}
+	return nil
+}
+
+// ccIndexURLs returns the index API URLs of the n most recent crawls
+func ccIndexURLs(n int) ([]string, error) {
+	body, err := getPage(ccCollInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	var colls []struct {
+		ID     string `json:"id"`
+		CDXAPI string `json:"cdx-api"`
+	}
+	if err := json.Unmarshal(body, &colls); err != nil {
+		return nil, fmt.Errorf("decoding crawl list: %w", err)
+	}
+
+	// the list is newest first
+	out := make([]string, 0, n)
+	for _, c := range colls {
+		if len(out) == n {
+			break
+		}
+		out = append(out, c.CDXAPI)
+	}
+	return out, nil
+}
+
+// ccValues translates the query options into parameters for the Common
+// Crawl index server. It uses different field names and filter syntax
+// to the Wayback Machine, and has no collapse option
+func ccValues(domain string) url.Values {
+	target := domain + "/*"
+	if query.subs {
+		target = "*." + target
+	}
+
+	v := url.Values{}
+	v.Set("url", target)
+	v.Set("output", "json")
+	v.Set("fl", "urlkey,url,timestamp,status,mime,digest,length")
+
+	if query.from != "" {
+		v.Set("from", query.from)
+	}
+	if query.to != "" {
+		v.Set("to", query.to)
+	}
+	if query.status != "" {
+		v.Add("filter", ccFilter("status", query.status))
+	}
+	if query.mime != "" {
+		v.Add("filter", ccFilter("mime", query.mime))
+	}
+	if query.pageSize > 0 {
+		v.Set("pageSize", fmt.Sprint(query.pageSize))
+	}
+	return v
+}
+
+// ccFilter turns "200" into "~status:^(?:200)$" and "!404" into
+// "!~status:^(?:404)$". Filters are substring matches by default,
+// so they're anchored to match the Wayback Machine's behaviour
+func ccFilter(field, pattern string) string {
+	prefix := "~"
+	if strings.HasPrefix(pattern, "!") {
+		prefix = "!~"
+		pattern = pattern[1:]
+	}
+	return prefix + field + ":^(?:" + pattern + ")$"
+}
+
+func ccNumPages(index string, v url.Values) (int, error) {
+	pv := url.Values{}
+	for k, vv := range v {
+		pv[k] = vv
+	}
+	pv.Set("showNumPages", "true")
+
+	body, err := getPage(index + "?" + pv.Encode())
+	if errors.Is(err, errNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var info struct {
+		Pages int `json:"pages"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return 0, fmt.Errorf("unexpected page count %q", strings.TrimSpace(string(body)))
+	}
+	return info.Pages, nil
+}
+
+// ccPage returns the captures on one page of results. The
+// output is a JSON object per line rather than an array
+func ccPage(index string, v url.Values, page int) ([]capture, error) {
+	pv := url.Values{}
+	for k, vv := range v {
+		pv[k] = vv
+	}
+	pv.Set("page", fmt.Sprint(page))
+
+	body, err := getPage(index + "?" + pv.Encode())
+
+	// a 404 means there were no captures
+	if errors.Is(err, errNotFound) {
+		return []capture{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]capture, 0)
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var row struct {
+			URLKey    string `json:"urlkey"`
+			URL       string `json:"url"`
+			Timestamp string `json:"timestamp"`
+			Status    string `json:"status"`
+			Mime      string `json:"mime"`
+			Digest    string `json:"digest"`
+			Length    string `json:"length"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil || row.URL == "" {
+			continue
+		}
+
+		out = append(out, capture{
+			urlkey:   row.URLKey,
+			original: row.URL,
+			fields: map[string]string{
+				"timestamp":  row.Timestamp,
+				"statuscode": row.Status,
+				"mimetype":   row.Mime,
+				"digest":     row.Digest,
+				"length":     row.Length,
+			},
+		})
+	}
+
+	return out, sc.Err()
+}
@@ -2,34 +2,117 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"sync" // Added for WaitGroup
 	"time"
 )
 
-const fetchURL = "http://web.archive.org/cdx/search/cdx?url=%s/*&output=json&fl=original&collapse=urlkey"
-
 var (
 	concurrency int
 	verbose     bool
-	httpClient  = &http.Client{
-		Timeout: 30 * time.Second,
-	}
+	httpClient  = &http.Client{}
+
+	query       cdxQuery
+	fieldsFlag  string
+	latestOnly  bool
+	sourcesFlag string
+
+	// retries is how many times a page is retried before giving up on
+	// the domain; retryDelay is the delay before the first retry, and
+	// doubles after each one
+	retries    int
+	retryDelay = 5 * time.Second
+
+	timeout int
+
+	fetchMode bool
+	outputDir string
+
+	checkpointPath string
+	checkpoints    *checkpoint
+
+	// lines for different domains are written by different
+	// workers, so output goes through a lock
+	outputMu sync.Mutex
 )
 
 func init() {
 	flag.IntVar(&concurrency, "c", 10, "Number of concurrent requests")
 	flag.BoolVar(&verbose, "v", false, "Enable verbose output")
+
+	flag.BoolVar(&query.subs, "subs", false, "Include subdomains (*.domain/*) rather than just the domain itself (domain/*)")
+	flag.StringVar(&fieldsFlag, "fields", "", "Comma separated CDX fields to output before each URL: timestamp, statuscode, mimetype, digest, length")
+	flag.StringVar(&query.from, "from", "", "Only include captures from this date on (yyyy[MM[dd[hh[mm[ss]]]]])")
+	flag.StringVar(&query.to, "to", "", "Only include captures up to this date (yyyy[MM[dd[hh[mm[ss]]]]])")
+	flag.StringVar(&query.status, "status", "", "Only include captures with a status code matching this regex, e.g. 200 or '!404'")
+	flag.StringVar(&query.mime, "mime", "", "Only include captures with a mime type matching this regex, e.g. 'application/javascript'")
+	flag.StringVar(&query.collapse, "collapse", "urlkey", "CDX collapse option, e.g. urlkey (one capture per URL), digest, timestamp:8, or none")
+	flag.BoolVar(&latestOnly, "latest", false, "Output the most recent capture of each URL rather than the first (fetches every capture)")
+
+	flag.StringVar(&sourcesFlag, "sources", "wayback", "Comma separated sources to fetch URLs from: wayback, commoncrawl, otx, or all")
+	flag.IntVar(&ccCrawls, "cc-crawls", 1, "Number of the most recent Common Crawl crawls to search")
+
+	flag.BoolVar(&fetchMode, "fetch", false, "Download the archived content of the URLs on stdin instead of finding URLs")
+	flag.StringVar(&outputDir, "o", "out", "Directory to save downloaded snapshots in with -fetch")
+
+	flag.IntVar(&query.pageSize, "page-size", 0, "Number of CDX index blocks per page (default: the server's default)")
+	flag.IntVar(&retries, "retries", 5, "Number of times to retry a page that fails")
+	flag.StringVar(&checkpointPath, "checkpoint", "", "File to record progress in, so an interrupted run can be resumed")
+	flag.IntVar(&timeout, "t", 60, "Timeout in seconds for each page request")
 }
 
 func main() {
 	flag.Parse()
 
+	fields, err := parseFields(fieldsFlag)
+	if err != nil {
+		log.Fatalf("Invalid -fields: %v", err)
+	}
+
+	selected, err := selectSources(sourcesFlag)
+	if err != nil {
+		log.Fatalf("Invalid -sources: %v", err)
+	}
+
+	// the Wayback Machine on its own gives one sorted list that's already
+	// collapsed, but when there's more than one list of captures to merge
+	// a URL can turn up in several of them, so they're deduplicated as
+	// they're output, unless every capture was asked for
+	dedupe := (query.collapse == "urlkey" || latestOnly) &&
+		!(len(selected) == 1 && selected[0].name == "wayback")
+
+	// the fields that are output and the fields that are
+	// fetched differ for -latest, which needs the timestamps
+	query.fields = fields
+	if query.collapse == "none" {
+		query.collapse = ""
+	}
+	if latestOnly {
+		query.collapse = ""
+		if !contains(fields, "timestamp") {
+			query.fields = append(append([]string{}, fields...), "timestamp")
+		}
+	}
+
+	httpClient.Timeout = time.Duration(timeout) * time.Second
+
+	if checkpointPath != "" {
+		checkpoints, err = loadCheckpoint(checkpointPath)
+		if err != nil {
+			log.Fatalf("Failed to load checkpoint: %v", err)
+		}
+	}
+
+	if fetchMode {
+		fetchSnapshots()
+		return
+	}
+
 	var domains []string
 
 	if flag.NArg() > 0 {
@@ -67,14 +150,33 @@ func main() {
 				if verbose {
 					log.Printf("Fetching URLs for domain: %s\n", domain)
 				}
-				urls, err := getWaybackURLs(domain)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Failed to fetch URLs for [%s]: %v\n", domain, err)
-					continue
-				}
-
-				for _, url := range urls {
-					fmt.Println(url)
+				seen := make(map[string]bool)
+
+				for _, s := range selected {
+					name := s.name
+					e := &emitter{
+						latest:   latestOnly && s.sorted,
+						collapse: query.collapse == "urlkey" && s.sorted,
+						out: func(c capture) {
+							if dedupe {
+								if seen[c.original] {
+									return
+								}
+								seen[c.original] = true
+							}
+
+							c.source = name
+							outputMu.Lock()
+							fmt.Println(c.format(fields))
+							outputMu.Unlock()
+						},
+					}
+
+					err := s.fetch(domain, e)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to fetch URLs for [%s] from %s: %v\n", domain, name, err)
+					}
+					e.flush()
 				}
 			}
 		}()
@@ -89,40 +191,54 @@ func main() {
 	wg.Wait() // Wait for all goroutines to finish
 }
 
-func getWaybackURLs(domain string) ([]string, error) {
-	out := make([]string, 0)
-	requestURL := fmt.Sprintf(fetchURL, domain)
+// fetchSnapshots downloads the archived content of each URL on stdin
+// (or given as arguments), printing the path each one was saved to
+func fetchSnapshots() {
+	jobs := make(chan snapshot)
+	var wg sync.WaitGroup
 
-	res, err := httpClient.Get(requestURL) // Use shared httpClient
-	if err != nil {
-		return out, fmt.Errorf("requesting %s: %w", requestURL, err)
-	}
-	defer res.Body.Close()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				p, err := s.download(outputDir)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to fetch snapshot of %s: %v\n", s.original, err)
+					continue
+				}
 
-	if res.StatusCode != http.StatusOK {
-		return out, fmt.Errorf("received non-200 status code (%d) from %s", res.StatusCode, requestURL)
+				outputMu.Lock()
+				fmt.Printf("%s: %s\n", p, s.original)
+				outputMu.Unlock()
+			}
+		}()
 	}
 
-	var wrapper [][]string
-	// Use json.NewDecoder for potentially large responses
-	if err := json.NewDecoder(res.Body).Decode(&wrapper); err != nil {
-		return out, fmt.Errorf("decoding JSON from %s: %w", requestURL, err)
+	var sc *bufio.Scanner
+	if flag.NArg() > 0 {
+		sc = bufio.NewScanner(strings.NewReader(strings.Join(flag.Args(), "\n")))
+	} else {
+		sc = bufio.NewScanner(os.Stdin)
 	}
-
-	if len(wrapper) > 0 {
-		// Check if the first row is the header "original" and skip it.
-		// This is more robust than just skipping the first row unconditionally.
-		if len(wrapper[0]) == 1 && wrapper[0][0] == "original" {
-			wrapper = wrapper[1:]
+	for sc.Scan() {
+		if s, ok := parseSnapshot(sc.Text()); ok {
+			jobs <- s
 		}
 	}
-	
-	for _, urls := range wrapper {
-		// Each 'urls' here is expected to be a slice containing a single URL string.
-		if len(urls) > 0 {
-			out = append(out, urls[0])
-		}
+	close(jobs)
+
+	if err := sc.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
 	}
+	wg.Wait()
+}
 
-	return out, nil
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
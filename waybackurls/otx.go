@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// otxMaxPages stops a runaway loop if has_next never turns false
+const otxMaxPages = 1000
+
+// getOTXURLs fetches the URLs AlienVault OTX has seen for domain,
+// passing them to e. OTX can't filter on the server, so the date and
+// status filters are applied here. It doesn't know mime types, so
+// with -mime nothing is returned
+func getOTXURLs(domain string, e *emitter) error {
+	if query.mime != "" {
+		if verbose {
+			log.Printf("Skipping otx for %s: it has no mime types to filter on\n", domain)
+		}
+		return nil
+	}
+
+	status, err := statusMatcher(query.status)
+	if err != nil {
+		return err
+	}
+
+	// the domain endpoint includes subdomains; the hostname one doesn't
+	kind := "hostname"
+	if query.subs {
+		kind = "domain"
+	}
+
+	for page := 1; page <= otxMaxPages; page++ {
+		if verbose {
+			log.Printf("Fetching otx page %d for %s\n", page, domain)
+		}
+
+		url := fmt.Sprintf("https://otx.alienvault.com/api/v1/indicators/%s/%s/url_list?limit=500&page=%d", kind, domain, page)
+		body, err := getPage(url)
+		if errors.Is(err, errNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("page %d: %w", page, err)
+		}
+
+		var res struct {
+			URLs []struct {
+				URL      string `json:"url"`
+				Date     string `json:"date"`
+				HTTPCode int    `json:"httpcode"`
+			} `json:"url_list"`
+			HasNext bool `json:"has_next"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return fmt.Errorf("decoding JSON from %s: %w", url, err)
+		}
+
+		for _, u := range res.URLs {
+			ts := otxTimestamp(u.Date)
+			if !inDateRange(ts, query.from, query.to) {
+				continue
+			}
+
+			code := ""
+			if u.HTTPCode != 0 {
+				code = fmt.Sprint(u.HTTPCode)
+			}
+			if !status(code) {
+				continue
+			}
+
+			e.add(capture{
+				original: u.URL,
+				fields: map[string]string{
+					"timestamp":  ts,
+					"statuscode": code,
+				},
+			})
+		}
+
+		if !res.HasNext {
+			break
+		}
+	}
+
+	return nil
+}
+
+// otxTimestamp converts an OTX date (2006-01-02T15:04:05) to a CDX
+// style timestamp (20060102150405), or "" if it can't be parsed
+func otxTimestamp(date string) string {
+	t, err := time.Parse("2006-01-02T15:04:05", strings.SplitN(date, ".", 2)[0])
+	if err != nil {
+		return ""
+	}
+	return t.Format("20060102150405")
+}
+
+// inDateRange checks a CDX style timestamp against -from and -to in the
+// same way as the CDX API: both are prefixes, so -to 2019 includes all
+// of 2019. Captures with no timestamp only pass if there's no range
+func inDateRange(ts, from, to string) bool {
+	if from == "" && to == "" {
+		return true
+	}
+	if ts == "" {
+		return false
+	}
+	if from != "" && ts[:min(len(from), len(ts))] < from {
+		return false
+	}
+	if to != "" && ts[:min(len(to), len(ts))] > to {
+		return false
+	}
+	return true
+}
+
+// statusMatcher returns a func that applies a -status pattern
+// to a status code, anchored like the CDX API's filters
+func statusMatcher(pattern string) (func(string) bool, error) {
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+
+	invert := strings.HasPrefix(pattern, "!")
+	if invert {
+		pattern = pattern[1:]
+	}
+
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid -status: %w", err)
+	}
+	return func(code string) bool {
+		return re.MatchString(code) != invert
+	}, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// errNotFound is returned by getPage for 404s, which some
+// archives use to mean there are no results
+var errNotFound = errors.New("not found (404)")
+
+// getPage fetches url, retrying with an increasing delay if the
+// request fails or the server returns anything other than a 200
+func getPage(url string) ([]byte, error) {
+	_, body, err := getWithRetries(url)
+	return body, err
+}
+
+// getWithRetries is getPage, but it also returns the final response
+// (after any redirects) so its headers and URL can be used. The
+// response body has already been read and closed
+func getWithRetries(url string) (*http.Response, []byte, error) {
+	var lastErr error
+	delay := retryDelay
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "Retrying %s in %s: %v\n", url, delay, lastErr)
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		res, err := httpClient.Get(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("reading response: %w", err)
+			continue
+		}
+
+		if res.StatusCode == http.StatusNotFound {
+			lastErr = errNotFound
+			break
+		}
+
+		if res.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("received non-200 status code (%d)", res.StatusCode)
+			// only rate limiting and server errors are worth retrying
+			if res.StatusCode != http.StatusTooManyRequests && res.StatusCode < 500 {
+				break
+			}
+			continue
+		}
+
+		return res, body, nil
+	}
+
+	return nil, nil, fmt.Errorf("requesting %s: %w", url, lastErr)
+}
+
+// fetchPages gets every page of results from a paginated index in
+// turn, passing the captures to e as each page arrives. With a
+// checkpoint, pages that were done by an earlier run for the same
+// key are skipped
+func fetchPages(key, domain string, numPages func() (int, error), page func(int) ([]capture, error), e *emitter) error {
+	p, ok := progress{}, false
+	if checkpoints != nil {
+		p, ok = checkpoints.get(key)
+	}
+
+	if !ok {
+		pages, err := numPages()
+		if err != nil {
+			return err
+		}
+		p = progress{Domain: domain, Pages: pages}
+	} else if verbose {
+		log.Printf("Resuming %s at page %d of %d\n", domain, p.Next+1, p.Pages)
+	}
+
+	for ; p.Next < p.Pages; p.Next++ {
+		if verbose {
+			log.Printf("Fetching page %d of %d for %s\n", p.Next+1, p.Pages, domain)
+		}
+
+		captures, err := page(p.Next)
+		if err != nil {
+			return fmt.Errorf("page %d of %d: %w", p.Next+1, p.Pages, err)
+		}
+
+		for _, c := range captures {
+			e.add(c)
+		}
+
+		if checkpoints != nil {
+			next := p
+			next.Next++
+			if err := checkpoints.set(key, next); err != nil {
+				return fmt.Errorf("saving checkpoint: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// A checkpoint records how far through its pages each query has got,
+// so that an interrupted run can carry on where it stopped. It's saved
+// to disk after every page
+type checkpoint struct {
+	path string
+
+	mu      sync.Mutex
+	Queries map[string]progress `json:"queries"`
+}
+
+type progress struct {
+	Domain string `json:"domain"`
+	Pages  int    `json:"pages"`
+	Next   int    `json:"next"`
+}
+
+// loadCheckpoint reads the checkpoint at path, or
+// starts a new one if the file doesn't exist yet
+func loadCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{path: path, Queries: make(map[string]progress)}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %w", path, err)
+	}
+	if c.Queries == nil {
+		c.Queries = make(map[string]progress)
+	}
+	return c, nil
+}
+
+// get returns the progress for a query, and false if there isn't any yet
+func (c *checkpoint) get(key string) (progress, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.Queries[key]
+	return p, ok
+}
+
+// set records the progress for a query and saves the checkpoint. It's
+// written to a temporary file first so that being killed part way
+// through writing doesn't lose the whole thing
+func (c *checkpoint) set(key string, p progress) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Queries[key] = p
+
+	raw, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
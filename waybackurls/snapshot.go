@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const waybackWeb = "https://web.archive.org/web/"
+
+// A snapshot is an archived URL to download, and the timestamp of the
+// capture to get. With no timestamp the most recent capture is used
+type snapshot struct {
+	original  string
+	timestamp string
+}
+
+// archiveURLRe matches Wayback Machine URLs like
+// https://web.archive.org/web/20200101000000/https://example.com/
+// with or without a modifier like id_ after the timestamp
+var archiveURLRe = regexp.MustCompile(`^https?://web\.archive\.org/web/(\d{1,14})[a-z_]*/(.+)$`)
+
+var timestampRe = regexp.MustCompile(`^\d{14}$`)
+
+// parseSnapshot reads a line of input. It can be a plain URL, a line of
+// waybackurls output with the timestamp field (RFC3339 or CDX style)
+// somewhere before the URL, or a Wayback Machine URL
+func parseSnapshot(line string) (snapshot, bool) {
+	parts := strings.Fields(line)
+	if len(parts) == 0 {
+		return snapshot{}, false
+	}
+
+	s := snapshot{original: parts[len(parts)-1]}
+	if m := archiveURLRe.FindStringSubmatch(s.original); m != nil {
+		return snapshot{original: m[2], timestamp: m[1]}, true
+	}
+
+	for _, p := range parts[:len(parts)-1] {
+		if timestampRe.MatchString(p) {
+			s.timestamp = p
+			break
+		}
+		if t, err := time.Parse(time.RFC3339, p); err == nil {
+			s.timestamp = t.UTC().Format("20060102150405")
+			break
+		}
+	}
+
+	return s, true
+}
+
+// rawURL returns the id_ form of the archive URL, which serves the
+// content exactly as it was captured rather than rewritten for replay.
+// Without a timestamp, asking for the current time gets redirected to
+// the most recent capture
+func (s snapshot) rawURL() string {
+	ts := s.timestamp
+	if ts == "" {
+		ts = time.Now().UTC().Format("20060102150405")
+	}
+	return waybackWeb + ts + "id_/" + s.original
+}
+
+// capturedAtRe gets the timestamp of the capture that was actually
+// served from the final URL, after any redirect to the nearest capture
+var capturedAtRe = regexp.MustCompile(`/web/(\d{14})id_/`)
+
+// download saves the snapshot in dir, laid out like fff's output:
+// dir/host/path/timestamp.hash.body, with the archived response
+// headers alongside in a .headers file. It returns the path of
+// the body file. Snapshots that have already been saved with a
+// known timestamp aren't downloaded again
+func (s snapshot) download(dir string) (string, error) {
+	u, err := url.Parse(s.original)
+	if err != nil || !safeHostname(u.Hostname()) {
+		return "", fmt.Errorf("invalid URL %s", s.original)
+	}
+
+	base := filepath.Join(dir, u.Hostname(), normalisePath(u))
+	hash := sha1.Sum([]byte(s.original))
+
+	if s.timestamp != "" && len(s.timestamp) == 14 {
+		p := filepath.Join(base, fmt.Sprintf("%s.%x.body", s.timestamp, hash))
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	archiveURL := s.rawURL()
+	res, body, err := getWithRetries(archiveURL)
+	if err != nil {
+		return "", err
+	}
+
+	ts := s.timestamp
+	if m := capturedAtRe.FindStringSubmatch(res.Request.URL.String()); m != nil {
+		ts = m[1]
+	}
+
+	if err := os.MkdirAll(base, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create dir: %w", err)
+	}
+
+	p := filepath.Join(base, fmt.Sprintf("%s.%x.body", ts, hash))
+	if err := os.WriteFile(p, body, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write file contents: %w", err)
+	}
+
+	headersPath := filepath.Join(base, fmt.Sprintf("%s.%x.headers", ts, hash))
+	if err := os.WriteFile(headersPath, []byte(formatHeaders(res)), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write headers file contents: %w", err)
+	}
+
+	return p, nil
+}
+
+// formatHeaders writes the request URL and the response headers in the
+// same format as fff. The original response's headers are the ones the
+// archive sends back prefixed with X-Archive-Orig-
+func formatHeaders(res *http.Response) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("GET %s\n\n", res.Request.URL))
+	buf.WriteString(fmt.Sprintf("< %s %s\n", res.Proto, res.Status))
+
+	names := make([]string, 0, len(res.Header))
+	for k := range res.Header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		for _, v := range res.Header[k] {
+			buf.WriteString(fmt.Sprintf("< %s: %s\n", k, v))
+		}
+	}
+	return buf.String()
+}
+
+// safeHostname reports whether host can be used as a directory name.
+// Like .. in the path, a hostname of .. (from e.g. http://../etc/x)
+// or one with slashes in would write outside the output directory
+func safeHostname(host string) bool {
+	if host == "" || host == "." || host == ".." {
+		return false
+	}
+	return !strings.ContainsAny(host, `/\`)
+}
+
+var unsafePathRe = regexp.MustCompile(`[^a-zA-Z0-9/._-]+`)
+
+// normalisePath makes the URL's path safe to use as a directory, as fff does
+func normalisePath(u *url.URL) string {
+	p := unsafePathRe.ReplaceAllString(u.Path, "-")
+
+	// don't let .. in the path write outside the output directory
+	return filepath.Join("/", p)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A source is an archive that URLs for a domain can be fetched from
+type source struct {
+	name string
+
+	// sorted sources give their captures in urlkey order, which
+	// -collapse urlkey and -latest rely on; see emitter
+	sorted bool
+
+	fetch func(domain string, e *emitter) error
+}
+
+var sources = map[string]source{
+	"wayback":     {"wayback", true, getWaybackURLs},
+	"commoncrawl": {"commoncrawl", true, getCommonCrawlURLs},
+	"otx":         {"otx", false, getOTXURLs},
+}
+
+// selectSources parses a comma separated list of source names,
+// keeping them in the order given. "all" means every source
+func selectSources(list string) ([]source, error) {
+	out := make([]source, 0)
+	seen := make(map[string]bool)
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+
+		if name == "all" {
+			return allSources(), nil
+		}
+
+		s, ok := sources[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown source '%s'", name)
+		}
+		seen[name] = true
+		out = append(out, s)
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no sources given")
+	}
+	return out, nil
+}
+
+// allSources returns every source, with the Wayback Machine first
+func allSources() []source {
+	out := make([]source, 0, len(sources))
+	for _, s := range sources {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].name == "wayback" || out[j].name == "wayback" {
+			return out[i].name == "wayback"
+		}
+		return out[i].name < out[j].name
+	})
+	return out
+}
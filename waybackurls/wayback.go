@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// getWaybackURLs fetches every page of Wayback Machine
+// CDX results for domain, passing the captures to e
+func getWaybackURLs(domain string, e *emitter) error {
+	return fetchPages(query.key(domain), domain,
+		func() (int, error) { return waybackNumPages(domain) },
+		func(page int) ([]capture, error) { return waybackPage(domain, page) },
+		e,
+	)
+}
+
+// waybackNumPages asks the CDX API how many pages of results there are for domain
+func waybackNumPages(domain string) (int, error) {
+	body, err := getPage(query.numPagesURL(domain))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(body)))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected page count %q", strings.TrimSpace(string(body)))
+	}
+	return n, nil
+}
+
+// waybackPage returns the captures on one page of results for domain
+func waybackPage(domain string, page int) ([]capture, error) {
+	url := query.pageURL(domain, page)
+	body, err := getPage(url)
+	if err != nil {
+		return nil, err
+	}
+
+	// empty pages sometimes have no body at all rather than []
+	if len(bytes.TrimSpace(body)) == 0 {
+		return []capture{}, nil
+	}
+
+	var rows [][]string
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, fmt.Errorf("decoding JSON from %s: %w", url, err)
+	}
+
+	return parseRows(rows, query.fl()), nil
+}